@@ -0,0 +1,188 @@
+// Package client is a client of a remote Discover, polling its services endpoint.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarktrimble/giant"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+//go:generate moq -pkg mock -out mock/mock.go . Sender
+
+const (
+	indexHeader       string = "X-Discover-Index"
+	notModifiedStatus string = "unexpected status code 304"
+	limitFactor       int    = 3
+	limitBurst        int    = 3
+)
+
+// Sender specifies an http client, as satisfied by giant.
+type Sender interface {
+	Send(ctx context.Context, rq giant.Request) (response *http.Response, err error)
+}
+
+// Config is Client configuration.
+type Config struct {
+	PollInterval time.Duration `json:"poll_interval" desc:"long polling duration, best kept within client timeout" default:"30s"`
+	Path         string        `json:"path" desc:"path to services on the remote discover" default:"/services"`
+	Capability   string        `json:"capability,omitempty" desc:"only poll services with this capability"`
+	MinCapacity  int           `json:"min_capacity,omitempty" desc:"only poll services with at least this capacity"`
+}
+
+// Client polls the services of a remote Discover.
+type Client struct {
+	Sender       Sender
+	Limiter      *rate.Limiter
+	LimitDelay   time.Duration
+	PollInterval time.Duration
+	Path         string
+	Query        url.Values
+	Idx          uint64
+	ETag         string
+	data         []byte
+}
+
+// New creates a Client from Config.
+func (cfg *Config) New(sender Sender) *Client {
+
+	rateLimit := rate.Every(cfg.PollInterval / time.Duration(limitFactor))
+
+	query := url.Values{}
+	if cfg.Capability != "" {
+		query.Set("capability", cfg.Capability)
+		if cfg.MinCapacity > 0 {
+			query.Set("min_capacity", strconv.Itoa(cfg.MinCapacity))
+		}
+	}
+
+	return &Client{
+		Sender:       sender,
+		Limiter:      rate.NewLimiter(rateLimit, limitBurst),
+		PollInterval: cfg.PollInterval,
+		Path:         cfg.Path,
+		Query:        query,
+	}
+}
+
+// Poll long-polls services, returning them as a json array.
+//
+// On the first poll (when Idx is 0) it returns right away.
+// On subsequent polls it returns on a change of services or at the end of PollInterval,
+// the remote Discover's max wait permitting.
+// When not blocking and services are not modified, as per ETag, the last services polled are returned.
+// Blocking polls are not made conditional, as a 304 reported as an error, by giant's statusrt,
+// would lose the remote's index along with the response.
+//
+// Rate-limiting behaves as for consul.Poll.
+func (clt *Client) Poll(ctx context.Context) (data []byte, err error) {
+
+	delay := clt.Limiter.Reserve().Delay()
+	clt.LimitDelay += delay
+	time.Sleep(delay)
+
+	query := url.Values{}
+	for key, vals := range clt.Query {
+		query[key] = vals
+	}
+	if clt.Idx != 0 {
+		query.Set("index", strconv.FormatUint(clt.Idx, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(clt.PollInterval.Seconds())))
+	}
+
+	path := clt.Path
+	if len(query) > 0 {
+		path = fmt.Sprintf("%s?%s", path, query.Encode())
+	}
+
+	rq := giant.Request{
+		Method:  "GET",
+		Path:    path,
+		Headers: map[string]string{"Accept": "application/json"},
+	}
+	if clt.Idx == 0 && clt.data != nil && clt.ETag != "" {
+		rq.Headers["If-None-Match"] = clt.ETag
+	}
+
+	response, err := clt.Sender.Send(ctx, rq)
+	if notModified(err) {
+		// statusrt from giant reports 304 as an error and swallows the response, index and all
+		data = clt.data
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	clt.index(response)
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		data = clt.data
+		return
+	case http.StatusOK:
+	default:
+		err = errors.Errorf("unexpected status code %d from: %s", response.StatusCode, path)
+		return
+	}
+
+	data, err = decode(response.Body)
+	if err != nil {
+		return
+	}
+
+	clt.data = data
+	clt.ETag = response.Header.Get("etag")
+	return
+}
+
+// unexported
+
+func (clt *Client) index(response *http.Response) {
+
+	// an index going backwards, as when the remote restarts, is taken as is
+	// and without one there's no blocking
+
+	idx, err := strconv.ParseUint(response.Header.Get(indexHeader), 10, 64)
+	if err != nil {
+		idx = 0
+	}
+
+	clt.Idx = idx
+}
+
+func decode(reader io.Reader) (data []byte, err error) {
+
+	body := struct {
+		Services json.RawMessage `json:"services"`
+	}{}
+
+	err = json.NewDecoder(reader).Decode(&body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to decode services response")
+		return
+	}
+	if body.Services == nil {
+		err = errors.Errorf("no services found in response")
+		return
+	}
+
+	data = body.Services
+	return
+}
+
+func notModified(err error) bool {
+
+	return err != nil && strings.Contains(err.Error(), notModifiedStatus)
+}
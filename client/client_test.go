@@ -0,0 +1,267 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarktrimble/giant"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"configstate/chi"
+	. "configstate/client"
+	"configstate/client/mock"
+	"configstate/discover"
+	dscmock "configstate/discover/mock"
+	"configstate/entity"
+)
+
+func TestClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Client Suite")
+}
+
+var _ = Describe("Client", func() {
+
+	var (
+		ctx    context.Context
+		cfg    *Config
+		sender *mock.SenderMock
+		clt    *Client
+		status int
+		header http.Header
+		body   string
+		data   []byte
+		err    error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cfg = &Config{
+			PollInterval: 30 * time.Second,
+			Path:         "/services",
+			Capability:   "resize",
+			MinCapacity:  3,
+		}
+
+		status = 200
+		header = http.Header{"X-Discover-Index": {"7"}, "Etag": {`"abc"`}}
+		body = `{"services":[{"uri":"http://pool04.boxworld.org/api/v2"}],"stale":false}`
+
+		sender = &mock.SenderMock{
+			SendFunc: func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: status,
+					Header:     header,
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}, nil
+			},
+		}
+
+		clt = cfg.New(sender)
+		clt.Limiter = rate.NewLimiter(rate.Inf, 0)
+	})
+
+	JustBeforeEach(func() {
+		data, err = clt.Poll(ctx)
+	})
+
+	When("all is well", func() {
+		It("responds with services, noting index and etag", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal(`[{"uri":"http://pool04.boxworld.org/api/v2"}]`))
+			Expect(clt.Idx).To(BeEquivalentTo(7))
+			Expect(clt.ETag).To(Equal(`"abc"`))
+
+			rq := sender.SendCalls()[0].Rq
+			Expect(rq.Method).To(Equal("GET"))
+			Expect(rq.Path).To(Equal("/services?capability=resize&min_capacity=3"))
+			Expect(rq.Headers).ToNot(HaveKey("If-None-Match"))
+		})
+
+		It("blocks on the next poll", func() {
+			header = http.Header{"X-Discover-Index": {"8"}, "Etag": {`"def"`}}
+
+			_, err = clt.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clt.Idx).To(BeEquivalentTo(8))
+
+			rq := sender.SendCalls()[1].Rq
+			Expect(rq.Path).To(Equal("/services?capability=resize&index=7&min_capacity=3&wait=30s"))
+			Expect(rq.Headers).ToNot(HaveKey("If-None-Match"))
+		})
+
+		It("follows the index when it moves on under the same etag", func() {
+			header = http.Header{"X-Discover-Index": {"9"}, "Etag": {`"abc"`}}
+
+			again, err := clt.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again).To(Equal(data))
+			Expect(clt.Idx).To(BeEquivalentTo(9))
+
+			_, err = clt.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			rq := sender.SendCalls()[2].Rq
+			Expect(rq.Path).To(Equal("/services?capability=resize&index=9&min_capacity=3&wait=30s"))
+			Expect(rq.Headers).ToNot(HaveKey("If-None-Match"))
+		})
+
+		It("responds with the last services when not modified", func() {
+			status = 304
+			body = ""
+
+			again, err := clt.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again).To(Equal(data))
+		})
+
+		It("responds with the last services when not modified as reported by statusrt", func() {
+			sender.SendFunc = func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+				return nil, errors.New("unexpected status code 304 with body: ")
+			}
+
+			again, err := clt.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again).To(Equal(data))
+		})
+	})
+
+	When("the index is missing", func() {
+		BeforeEach(func() {
+			header = http.Header{"Etag": {`"abc"`}}
+		})
+
+		It("does not block on the next poll, making it conditional instead", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clt.Idx).To(BeZero())
+
+			_, err = clt.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			rq := sender.SendCalls()[1].Rq
+			Expect(rq.Path).To(Equal("/services?capability=resize&min_capacity=3"))
+			Expect(rq.Headers["If-None-Match"]).To(Equal(`"abc"`))
+		})
+	})
+
+	When("status is unexpected", func() {
+		BeforeEach(func() {
+			status = 500
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("unexpected status code 500")))
+		})
+	})
+
+	When("services are missing from the response", func() {
+		BeforeEach(func() {
+			body = `{"not":"found"}`
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("no services")))
+		})
+	})
+
+	When("sending fails", func() {
+		BeforeEach(func() {
+			sender.SendFunc = func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+				return nil, errors.New("oops")
+			}
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError("oops"))
+		})
+	})
+})
+
+var _ = Describe("Chaining Discover off Discover", func() {
+
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		payload  string
+		upstream *discover.Discover
+		server   *httptest.Server
+		chained  *discover.Discover
+	)
+
+	logger := func() *dscmock.LoggerMock {
+		return &dscmock.LoggerMock{
+			ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+			InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+			WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+				return ctx
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		payload = `[{"uri":"http://pool04.boxworld.org/api/v2","capabilities":[{"name":"resize","capacity":5}]}]`
+
+		// upstream polls payload as it changes, blocking in between
+
+		upstream = (&discover.Config{ReadyTimeout: time.Second}).New(logger(), &dscmock.PollerMock{
+			PollFunc: func(ctx context.Context) ([]byte, error) {
+				select {
+				case <-time.After(10 * time.Millisecond):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				return []byte(payload), nil
+			},
+		})
+		err := upstream.Start(ctx, &wg)
+		Expect(err).ToNot(HaveOccurred())
+
+		rtr := chi.New()
+		upstream.Register(rtr)
+		server = httptest.NewServer(rtr)
+
+		sender := (&giant.Config{BaseUri: server.URL, Timeout: 5 * time.Second, TimeoutShort: time.Second}).New()
+		clt := (&Config{PollInterval: 2 * time.Second, Path: "/services"}).New(sender)
+		clt.Limiter = rate.NewLimiter(rate.Inf, 0)
+
+		chained = (&discover.Config{ReadyTimeout: time.Second}).New(logger(), clt)
+		err = chained.Start(ctx, &wg)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+		server.Close()
+		wg.Wait()
+	})
+
+	It("follows upstream services", func() {
+		Expect(chained.Services()).To(Equal(entity.Services{
+			{Uri: "http://pool04.boxworld.org/api/v2", Caps: []entity.Capability{{Name: "resize", Capacity: 5}}},
+		}))
+
+		mu.Lock()
+		payload = `[{"uri":"http://pool04.boxworld.org/api/v2","capabilities":[{"name":"resize","capacity":55}]}]`
+		mu.Unlock()
+
+		Eventually(chained.Services).Should(Equal(entity.Services{
+			{Uri: "http://pool04.boxworld.org/api/v2", Caps: []entity.Capability{{Name: "resize", Capacity: 55}}},
+		}))
+	})
+})
@@ -10,7 +10,10 @@ import (
 
 	"github.com/clarktrimble/delish"
 	"github.com/clarktrimble/delish/graceful"
+	"github.com/clarktrimble/delish/mid"
 	"github.com/clarktrimble/giant"
+	"github.com/clarktrimble/giant/basicrt"
+	"github.com/clarktrimble/giant/logrt"
 	"github.com/clarktrimble/hondo"
 	"github.com/clarktrimble/launch"
 	"github.com/clarktrimble/sabot"
@@ -32,11 +35,12 @@ var (
 )
 
 type Config struct {
-	Version      string         `json:"version" ignored:"true"`
-	Logger       *sabot.Config  `json:"logger"`
-	ConsulClient *giant.Config  `json:"consul_http_client"`
-	Consul       *consul.Config `json:"consul"`
-	Server       *delish.Config `json:"http_server"`
+	Version      string           `json:"version" ignored:"true"`
+	Logger       *sabot.Config    `json:"logger"`
+	ConsulClient *giant.Config    `json:"consul_http_client"`
+	Consul       *consul.Config   `json:"consul"`
+	Discover     *discover.Config `json:"discover"`
+	Server       *delish.Config   `json:"http_server"`
 }
 
 func main() {
@@ -59,16 +63,27 @@ func main() {
 
 	// start discovery and register handler
 
-	client := cfg.ConsulClient.NewWithTrippers(lgr)
+	// without statusrt, so as to see the consul index on 404
+
+	client := cfg.ConsulClient.New()
+	client.Use(logrt.New(lgr, cfg.ConsulClient.RedactHeaders, cfg.ConsulClient.SkipBody))
+	if cfg.ConsulClient.User != "" && cfg.ConsulClient.Pass != "" {
+		client.Use(basicrt.New(cfg.ConsulClient.User, string(cfg.ConsulClient.Pass)))
+	}
+
 	csl := cfg.Consul.New(client)
-	dsc := &discover.Discover{Poller: csl, Logger: lgr}
+	dsc := cfg.Discover.New(lgr, csl)
 
-	dsc.Start(ctx, &wg)
+	err := dsc.Start(ctx, &wg)
+	launch.Check(ctx, lgr, err)
 	dsc.Register(rtr)
 
 	// start server and wait for shutdown
 
+	// event streams and blocking queries go around response logging, as it buffers
+
 	server := cfg.Server.NewWithLog(ctx, rtr, lgr)
+	server.Handler = discover.Unbuffered(server.Handler, mid.ReplaceCtx(ctx, mid.LogRequest(lgr, hondo.Rand, rtr)))
 	server.Start(ctx, &wg)
 	graceful.Wait(ctx)
 }
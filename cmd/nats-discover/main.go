@@ -8,6 +8,7 @@ import (
 
 	"github.com/clarktrimble/delish"
 	"github.com/clarktrimble/delish/graceful"
+	"github.com/clarktrimble/delish/mid"
 	"github.com/clarktrimble/hondo"
 	"github.com/clarktrimble/launch"
 	"github.com/clarktrimble/sabot"
@@ -29,10 +30,11 @@ var (
 )
 
 type Config struct {
-	Version string         `json:"version" ignored:"true"`
-	Logger  *sabot.Config  `json:"logger"`
-	Nats    *nats.Config   `json:"nats"`
-	Server  *delish.Config `json:"http_server"`
+	Version  string           `json:"version" ignored:"true"`
+	Logger   *sabot.Config    `json:"logger"`
+	Nats     *nats.Config     `json:"nats"`
+	Discover *discover.Config `json:"discover"`
+	Server   *delish.Config   `json:"http_server"`
 }
 
 func main() {
@@ -58,13 +60,17 @@ func main() {
 	nts, err := cfg.Nats.New()
 	launch.Check(ctx, lgr, err)
 
-	dsc := &discover.Discover{Poller: nts, Logger: lgr}
-	dsc.Start(ctx, &wg)
+	dsc := cfg.Discover.New(lgr, nts)
+	err = dsc.Start(ctx, &wg)
+	launch.Check(ctx, lgr, err)
 	dsc.Register(rtr)
 
 	// start server and wait for shutdown
 
+	// event streams and blocking queries go around response logging, as it buffers
+
 	server := cfg.Server.NewWithLog(ctx, rtr, lgr)
+	server.Handler = discover.Unbuffered(server.Handler, mid.ReplaceCtx(ctx, mid.LogRequest(lgr, hondo.Rand, rtr)))
 	server.Start(ctx, &wg)
 	graceful.Wait(ctx)
 }
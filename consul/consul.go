@@ -1,36 +1,48 @@
-// Package consul is a Consul Key-Value client.
+// Package consul is a Consul Key-Value and health client.
 package consul
 
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/clarktrimble/giant"
 	"github.com/pkg/errors"
 	"golang.org/x/time/rate"
 )
 
 //go:generate moq -pkg mock -out mock/mock.go . Client
 
-// Todo: consider adding recursive keys
-
 const (
-	kvPath      string = "/v1/kv/%s"
-	blockPath   string = "%s?index=%d&wait=%ds"
-	limitFactor int    = 3
-	limitBurst  int    = 3
+	kvPath           string = "/v1/kv/%s"
+	blockPath        string = "%s?index=%d&wait=%ds"
+	recursePath      string = "%s?recurse"
+	recurseBlockPath string = "%s?recurse&index=%d&wait=%ds"
+	notFoundStatus   string = "unexpected status code 404"
+	indexHeader      string = "X-Consul-Index"
+	limitFactor      int    = 3
+	limitBurst       int    = 3
 )
 
-// Client specifies an http client.
+// Client specifies an http client, as satisfied by giant.
+//
+// Response status is checked here, so that the blocking index can be had from headers even on 404.
+// For blocking to work when no keys are found, Send is expected to pass a 404 through
+// rather than report it as an error, as giant's statusrt does.
 type Client interface {
-	SendObject(ctx context.Context, method, path string, snd, rcv any) (err error)
+	Send(ctx context.Context, rq giant.Request) (response *http.Response, err error)
 }
 
 // Config is Consul configuration.
 type Config struct {
 	PollInterval time.Duration `json:"poll_interval" desc:"long polling duration" default:"1m"`
 	Key          string        `json:"key" desc:"key to be watched" required:"true"`
+	Recurse      bool          `json:"recurse" desc:"watch key as a prefix, values assembled into an array"`
 }
 
 // Consul is a Consul client.
@@ -40,6 +52,7 @@ type Consul struct {
 	LimitDelay   time.Duration
 	PollInterval time.Duration
 	Key          string
+	Recurse      bool
 	Idx          uint64
 }
 
@@ -53,6 +66,7 @@ func (cfg *Config) New(client Client) *Consul {
 		Limiter:      rate.NewLimiter(rateLimit, limitBurst),
 		PollInterval: cfg.PollInterval,
 		Key:          cfg.Key,
+		Recurse:      cfg.Recurse,
 	}
 }
 
@@ -65,11 +79,14 @@ func (csl *Consul) GetKv(ctx context.Context, key string, idx uint64) (value []b
 	}
 
 	results := []KvResult{}
-	err = csl.Client.SendObject(ctx, "GET", path, nil, &results)
+	_, found, err := getIndexed(ctx, csl.Client, path, &results)
 	if err != nil {
 		return
 	}
-	//fmt.Printf(">>> results: %#v\n", results)
+	if !found {
+		err = errors.Errorf("key not found: %s", key)
+		return
+	}
 
 	if len(results) != 1 {
 		err = errors.Errorf("non-singular kv results for key: %s", key)
@@ -86,7 +103,67 @@ func (csl *Consul) GetKv(ctx context.Context, key string, idx uint64) (value []b
 	return
 }
 
-// Poll long-polls a key.
+// GetPrefix gets all key/values under a prefix with a long poll if idx is not zero.
+//
+// Values are assembled, in key order, into a json array and so are expected to be json themselves.
+// The index returned is from the X-Consul-Index header, which accounts for deletes under the prefix
+// where the greatest ModifyIndex among the keys would not.
+// Keys without a value, such as "svc/" itself, are skipped.
+// When no keys are found under prefix, an empty array is returned.
+func (csl *Consul) GetPrefix(ctx context.Context, prefix string, idx uint64) (value []byte, latest uint64, err error) {
+
+	path := fmt.Sprintf(kvPath, prefix)
+	if idx != 0 {
+		path = fmt.Sprintf(recurseBlockPath, path, idx, int(csl.PollInterval.Seconds()))
+	} else {
+		path = fmt.Sprintf(recursePath, path)
+	}
+
+	results := []KvResult{}
+	latest, found, err := getIndexed(ctx, csl.Client, path, &results)
+	if notFound(err) {
+		// statusrt has swallowed the response, index and all, so no blocking next time
+		value = []byte("[]")
+		latest = 0
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	if !found {
+		// all keys gone, or not yet there
+		// latest is from the header, so as to block until one appears
+		value = []byte("[]")
+		return
+	}
+
+	values := []json.RawMessage{}
+	for _, result := range results {
+
+		var val []byte
+		val, err = base64.StdEncoding.DecodeString(result.Value)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to decode value for key: %s", result.Key)
+			return
+		}
+		if len(val) == 0 {
+			continue
+		}
+		if !json.Valid(val) {
+			err = errors.Errorf("invalid json value for key: %s", result.Key)
+			return
+		}
+
+		values = append(values, val)
+	}
+
+	value, err = json.Marshal(values)
+	err = errors.Wrapf(err, "somehow failed to assemble values under prefix: %s", prefix)
+	return
+}
+
+// Poll long-polls a key, or keys under a prefix when Recurse is set.
 //
 // On the first poll (when Idx is 0) it returns right away.
 // On subsequent polls (when Idx is not 0) it returns:
@@ -105,7 +182,11 @@ func (csl *Consul) Poll(ctx context.Context) (data []byte, err error) {
 	time.Sleep(delay)
 
 	var newIdx uint64
-	data, newIdx, err = csl.GetKv(ctx, csl.Key, csl.Idx)
+	if csl.Recurse {
+		data, newIdx, err = csl.GetPrefix(ctx, csl.Key, csl.Idx)
+	} else {
+		data, newIdx, err = csl.GetKv(ctx, csl.Key, csl.Idx)
+	}
 	if err != nil {
 		return
 	}
@@ -128,3 +209,50 @@ type KvResult struct {
 	Value       string
 	Flags       uint64
 }
+
+// unexported
+
+// getIndexed gets into rcv, returning the index from the response header and found false on 404.
+func getIndexed(ctx context.Context, client Client, path string, rcv any) (idx uint64, found bool, err error) {
+
+	rq := giant.Request{
+		Method:  "GET",
+		Path:    path,
+		Headers: map[string]string{"Accept": "application/json"},
+	}
+
+	response, err := client.Send(ctx, rq)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	// a missing or mangled header leaves idx at 0 and so no blocking
+
+	idx, _ = strconv.ParseUint(response.Header.Get(indexHeader), 10, 64)
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return
+	default:
+		err = errors.Errorf("unexpected status code %d from: %s", response.StatusCode, path)
+		return
+	}
+
+	err = json.NewDecoder(response.Body).Decode(rcv)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to decode response from: %s", path)
+		return
+	}
+
+	found = true
+	return
+}
+
+func notFound(err error) bool {
+
+	// statusrt from giant reports non-200's as an error, sniff for 404
+
+	return err != nil && strings.Contains(err.Error(), notFoundStatus)
+}
@@ -1,10 +1,17 @@
 package consul_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/clarktrimble/giant"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
@@ -35,13 +42,8 @@ var _ = Describe("Consul", func() {
 			PollInterval: time.Minute,
 		}
 		client = &mock.ClientMock{}
-		client.SendObjectFunc = func(ctx context.Context, method, path string, snd any, rcv any) error {
-			result := rcv.(*[]KvResult)
-			*result = append(*result, KvResult{
-				ModifyIndex: 48,
-				Value:       encoded,
-			})
-			return nil
+		client.SendFunc = func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+			return respond(http.StatusOK, "48", []KvResult{{ModifyIndex: 48, Value: encoded}}), nil
 		}
 		csl = cfg.New(client)
 		ctx = context.Background()
@@ -71,12 +73,11 @@ var _ = Describe("Consul", func() {
 				Expect(string(data)).To(Equal(decoded))
 				Expect(newIdx).To(BeEquivalentTo(48))
 
-				Expect(client.SendObjectCalls()).To(HaveLen(1))
-				call := client.SendObjectCalls()[0]
+				Expect(client.SendCalls()).To(HaveLen(1))
+				call := client.SendCalls()[0]
 				Expect(call.Ctx).To(Equal(ctx))
-				Expect(call.Method).To(Equal("GET"))
-				Expect(call.Path).To(Equal("/v1/kv/sample_key?index=5&wait=60s"))
-				Expect(call.Snd).To(BeNil())
+				Expect(call.Rq.Method).To(Equal("GET"))
+				Expect(call.Rq.Path).To(Equal("/v1/kv/sample_key?index=5&wait=60s"))
 			})
 		})
 
@@ -91,12 +92,154 @@ var _ = Describe("Consul", func() {
 				Expect(string(data)).To(Equal(decoded))
 				Expect(newIdx).To(BeEquivalentTo(48))
 
-				Expect(client.SendObjectCalls()).To(HaveLen(1))
-				call := client.SendObjectCalls()[0]
+				Expect(client.SendCalls()).To(HaveLen(1))
+				call := client.SendCalls()[0]
 				Expect(call.Ctx).To(Equal(ctx))
-				Expect(call.Method).To(Equal("GET"))
-				Expect(call.Path).To(Equal("/v1/kv/sample_key"))
-				Expect(call.Snd).To(BeNil())
+				Expect(call.Rq.Method).To(Equal("GET"))
+				Expect(call.Rq.Path).To(Equal("/v1/kv/sample_key"))
+			})
+		})
+
+		When("the key is not found", func() {
+			BeforeEach(func() {
+				key = "sample_key"
+				idx = 0
+				client.SendFunc = func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+					return respond(http.StatusNotFound, "52", nil), nil
+				}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("key not found: sample_key")))
+			})
+		})
+
+		When("access is denied", func() {
+			BeforeEach(func() {
+				key = "sample_key"
+				idx = 0
+				client.SendFunc = func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+					return respond(http.StatusForbidden, "", nil), nil
+				}
+			})
+
+			It("returns an error with the status", func() {
+				Expect(err).To(MatchError(ContainSubstring("unexpected status code 403")))
+			})
+		})
+	})
+
+	Describe("getting key-values under a prefix", func() {
+		var (
+			prefix  string
+			idx     uint64
+			newIdx  uint64
+			status  int
+			index   string
+			results []KvResult
+		)
+
+		BeforeEach(func() {
+			prefix = "svc"
+			status = http.StatusOK
+			index = "57"
+			results = []KvResult{
+				{Key: "svc/", ModifyIndex: 12},
+				{Key: "svc/05", ModifyIndex: 52, Value: base64.StdEncoding.EncodeToString([]byte(`{"uri":"http://pool05"}`))},
+				{Key: "svc/24", ModifyIndex: 48, Value: base64.StdEncoding.EncodeToString([]byte(`{"uri":"http://pool24"}`))},
+			}
+			client.SendFunc = func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+				return respond(status, index, results), nil
+			}
+		})
+
+		JustBeforeEach(func() {
+			data, newIdx, err = csl.GetPrefix(ctx, prefix, idx)
+		})
+
+		When("all is well", func() {
+			BeforeEach(func() {
+				idx = 5
+			})
+
+			It("responds with values assembled into an array and index from header", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(`[{"uri":"http://pool05"},{"uri":"http://pool24"}]`))
+				Expect(newIdx).To(BeEquivalentTo(57))
+
+				Expect(client.SendCalls()).To(HaveLen(1))
+				call := client.SendCalls()[0]
+				Expect(call.Rq.Method).To(Equal("GET"))
+				Expect(call.Rq.Path).To(Equal("/v1/kv/svc?recurse&index=5&wait=60s"))
+			})
+		})
+
+		When("index is 0", func() {
+			BeforeEach(func() {
+				idx = 0
+			})
+
+			It("does not long poll", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(newIdx).To(BeEquivalentTo(57))
+
+				Expect(client.SendCalls()).To(HaveLen(1))
+				Expect(client.SendCalls()[0].Rq.Path).To(Equal("/v1/kv/svc?recurse"))
+			})
+		})
+
+		When("a key is deleted", func() {
+			BeforeEach(func() {
+				idx = 57
+				index = "61"
+				results = results[:2]
+			})
+
+			It("responds without it and follows index from header", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(`[{"uri":"http://pool05"}]`))
+				Expect(newIdx).To(BeEquivalentTo(61))
+			})
+		})
+
+		When("no keys are found", func() {
+			BeforeEach(func() {
+				idx = 61
+				status = http.StatusNotFound
+				index = "64"
+				results = nil
+			})
+
+			It("responds with an empty array and follows index from header", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(`[]`))
+				Expect(newIdx).To(BeEquivalentTo(64))
+			})
+		})
+
+		When("an unexpected status is returned", func() {
+			BeforeEach(func() {
+				idx = 5
+				status = http.StatusInternalServerError
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("500"))
+			})
+		})
+
+		When("a value is not json", func() {
+			BeforeEach(func() {
+				idx = 5
+				results = []KvResult{
+					{Key: "svc/05", ModifyIndex: 52, Value: base64.StdEncoding.EncodeToString([]byte(`uri: pool05`))},
+				}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("svc/05"))
 			})
 		})
 	})
@@ -120,12 +263,11 @@ var _ = Describe("Consul", func() {
 				Expect(string(data)).To(Equal(decoded))
 				Expect(csl.Idx).To(BeEquivalentTo(48))
 
-				Expect(client.SendObjectCalls()).To(HaveLen(1))
-				call := client.SendObjectCalls()[0]
+				Expect(client.SendCalls()).To(HaveLen(1))
+				call := client.SendCalls()[0]
 				Expect(call.Ctx).To(Equal(ctx))
-				Expect(call.Method).To(Equal("GET"))
-				Expect(call.Path).To(Equal("/v1/kv/sample_key?index=5&wait=60s"))
-				Expect(call.Snd).To(BeNil())
+				Expect(call.Rq.Method).To(Equal("GET"))
+				Expect(call.Rq.Path).To(Equal("/v1/kv/sample_key?index=5&wait=60s"))
 			})
 		})
 
@@ -140,14 +282,25 @@ var _ = Describe("Consul", func() {
 				Expect(string(data)).To(Equal(decoded))
 				Expect(csl.Idx).To(BeEquivalentTo(0))
 
-				Expect(client.SendObjectCalls()).To(HaveLen(1))
-				call := client.SendObjectCalls()[0]
+				Expect(client.SendCalls()).To(HaveLen(1))
+				call := client.SendCalls()[0]
 				Expect(call.Ctx).To(Equal(ctx))
-				Expect(call.Method).To(Equal("GET"))
-				Expect(call.Path).To(Equal("/v1/kv/sample_key?index=55&wait=60s"))
-				Expect(call.Snd).To(BeNil())
+				Expect(call.Rq.Method).To(Equal("GET"))
+				Expect(call.Rq.Path).To(Equal("/v1/kv/sample_key?index=55&wait=60s"))
 			})
 		})
 	})
 
 })
+
+func respond(status int, index string, results any) *http.Response {
+
+	body, err := json.Marshal(results)
+	Expect(err).ToNot(HaveOccurred())
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Consul-Index": []string{index}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+}
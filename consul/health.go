@@ -0,0 +1,183 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	"configstate/entity"
+)
+
+const (
+	healthPath     string = "/v1/health/service/%s?passing"
+	healthBlock    string = "%s&index=%d&wait=%ds"
+	uriFormat      string = "%s://%s%s"
+	capacityPrefix string = "capacity_"
+	schemeMeta     string = "scheme"
+	pathMeta       string = "path"
+	defaultScheme  string = "http"
+)
+
+// HealthConfig is Consul health configuration.
+type HealthConfig struct {
+	PollInterval time.Duration `json:"poll_interval" desc:"long polling duration" default:"1m"`
+	Service      string        `json:"service" desc:"service to be watched" required:"true"`
+}
+
+// Health watches healthy instances of a service registered with Consul.
+//
+// Instances are mapped to entity.Service as follows:
+//   - Uri is scheme://address:port/path
+//   - address is from the service, falling back to the node
+//   - scheme and path are from service meta, defaulting to "http" and ""
+//   - each tag is a capability
+//   - capacity of a capability is from service meta "capacity_<tag>", defaulting to 1
+type Health struct {
+	Client       Client
+	Limiter      *rate.Limiter
+	LimitDelay   time.Duration
+	PollInterval time.Duration
+	Service      string
+	Idx          uint64
+}
+
+// New creates a Health from HealthConfig.
+func (cfg *HealthConfig) New(client Client) *Health {
+
+	rateLimit := rate.Every(cfg.PollInterval / time.Duration(limitFactor))
+
+	return &Health{
+		Client:       client,
+		Limiter:      rate.NewLimiter(rateLimit, limitBurst),
+		PollInterval: cfg.PollInterval,
+		Service:      cfg.Service,
+	}
+}
+
+// GetHealthy gets passing instances of a service with a long poll if idx is not zero.
+//
+// The index returned is from the X-Consul-Index header, which advances when an instance
+// drops out where the greatest ModifyIndex among those remaining would not.
+func (hlth *Health) GetHealthy(ctx context.Context, service string, idx uint64) (services entity.Services, latest uint64, err error) {
+
+	path := fmt.Sprintf(healthPath, service)
+	if idx != 0 {
+		path = fmt.Sprintf(healthBlock, path, idx, int(hlth.PollInterval.Seconds()))
+	}
+
+	results := []HealthResult{}
+	latest, _, err = getIndexed(ctx, hlth.Client, path, &results)
+	if err != nil {
+		return
+	}
+
+	// a 404 is not expected here, but would leave results empty all the same
+
+	services = entity.Services{}
+	for _, result := range results {
+
+		var svc entity.Service
+		svc, err = result.service()
+		if err != nil {
+			return
+		}
+		services = append(services, svc)
+	}
+
+	return
+}
+
+// Poll long-polls for healthy instances of Service, returning them json encoded.
+//
+// Polling and rate-limiting behave as for Consul.Poll.
+func (hlth *Health) Poll(ctx context.Context) (data []byte, err error) {
+
+	delay := hlth.Limiter.Reserve().Delay()
+	hlth.LimitDelay += delay
+	time.Sleep(delay)
+
+	services, newIdx, err := hlth.GetHealthy(ctx, hlth.Service, hlth.Idx)
+	if err != nil {
+		return
+	}
+
+	if newIdx < hlth.Idx {
+		newIdx = 0
+	}
+	hlth.Idx = newIdx
+
+	data, err = json.Marshal(services)
+	err = errors.Wrapf(err, "somehow failed to encode services: %#v", services)
+	return
+}
+
+// HealthResult is exported for test, bah.
+type HealthResult struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		ID          string
+		Service     string
+		Tags        []string
+		Address     string
+		Port        int
+		Meta        map[string]string
+		ModifyIndex uint64
+	}
+	Checks []struct {
+		CheckID     string
+		Status      string
+		ModifyIndex uint64
+	}
+}
+
+// unexported
+
+func (result HealthResult) service() (svc entity.Service, err error) {
+
+	address := result.Service.Address
+	if address == "" {
+		address = result.Node.Address
+	}
+
+	scheme := result.Service.Meta[schemeMeta]
+	if scheme == "" {
+		scheme = defaultScheme
+	}
+
+	host := net.JoinHostPort(address, strconv.Itoa(result.Service.Port))
+	path := result.Service.Meta[pathMeta]
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	svc = entity.Service{
+		Uri:  fmt.Sprintf(uriFormat, scheme, host, path),
+		Caps: []entity.Capability{},
+	}
+
+	for _, tag := range result.Service.Tags {
+
+		capacity := 1
+		if val, ok := result.Service.Meta[capacityPrefix+tag]; ok {
+			capacity, err = strconv.Atoi(val)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to parse capacity for service id: %s", result.Service.ID)
+				return
+			}
+		}
+
+		svc.Caps = append(svc.Caps, entity.Capability{Name: tag, Capacity: capacity})
+	}
+
+	return
+}
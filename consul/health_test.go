@@ -0,0 +1,161 @@
+package consul_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/clarktrimble/giant"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/consul"
+	"configstate/consul/mock"
+	"configstate/entity"
+)
+
+var _ = Describe("Health", func() {
+
+	var (
+		client  *mock.ClientMock
+		hlth    *Health
+		ctx     context.Context
+		index   string
+		results []HealthResult
+	)
+
+	BeforeEach(func() {
+		cfg := &HealthConfig{
+			PollInterval: time.Minute,
+			Service:      "resize",
+		}
+		client = &mock.ClientMock{
+			SendFunc: func(ctx context.Context, rq giant.Request) (*http.Response, error) {
+				return respond(http.StatusOK, index, results), nil
+			},
+		}
+		hlth = cfg.New(client)
+		ctx = context.Background()
+		index = "53"
+
+		results = []HealthResult{{}, {}}
+		results[0].Node.Address = "10.0.0.4"
+		results[0].Service.Port = 8080
+		results[0].Service.Tags = []string{"resize"}
+		results[0].Service.Meta = map[string]string{"capacity_resize": "23", "path": "api/v2"}
+		results[0].Service.ModifyIndex = 44
+		results[1].Node.Address = "10.0.0.5"
+		results[1].Service.Address = "pool24.boxworld.org"
+		results[1].Service.Port = 443
+		results[1].Service.Tags = []string{"resize", "crop"}
+		results[1].Service.Meta = map[string]string{"scheme": "https"}
+		results[1].Service.ModifyIndex = 48
+	})
+
+	Describe("getting healthy services", func() {
+		var (
+			services entity.Services
+			idx      uint64
+			newIdx   uint64
+			err      error
+		)
+
+		JustBeforeEach(func() {
+			services, newIdx, err = hlth.GetHealthy(ctx, "resize", idx)
+		})
+
+		When("all is well", func() {
+			BeforeEach(func() {
+				idx = 5
+			})
+
+			It("maps instances to services and responds with index", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(services).To(Equal(entity.Services{
+					{
+						Uri:  "http://10.0.0.4:8080/api/v2",
+						Caps: []entity.Capability{{Name: "resize", Capacity: 23}},
+					},
+					{
+						Uri:  "https://pool24.boxworld.org:443",
+						Caps: []entity.Capability{{Name: "resize", Capacity: 1}, {Name: "crop", Capacity: 1}},
+					},
+				}))
+				Expect(newIdx).To(BeEquivalentTo(53))
+
+				Expect(client.SendCalls()).To(HaveLen(1))
+				call := client.SendCalls()[0]
+				Expect(call.Rq.Method).To(Equal("GET"))
+				Expect(call.Rq.Path).To(Equal("/v1/health/service/resize?passing&index=5&wait=60s"))
+			})
+		})
+
+		When("an instance drops out", func() {
+			BeforeEach(func() {
+				idx = 53
+				index = "58"
+				results = results[1:]
+			})
+
+			It("responds without it and follows index from header", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(services).To(HaveLen(1))
+				Expect(services[0].Uri).To(Equal("https://pool24.boxworld.org:443"))
+				Expect(newIdx).To(BeEquivalentTo(58))
+			})
+		})
+
+		When("no instances are passing", func() {
+			BeforeEach(func() {
+				idx = 58
+				index = "61"
+				results = []HealthResult{}
+			})
+
+			It("responds with no services and follows index from header", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(services).To(BeEmpty())
+				Expect(newIdx).To(BeEquivalentTo(61))
+			})
+		})
+
+		When("capacity is not a number", func() {
+			BeforeEach(func() {
+				idx = 0
+				results[0].Service.Meta["capacity_resize"] = "lots"
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(client.SendCalls()[0].Rq.Path).To(Equal("/v1/health/service/resize?passing"))
+			})
+		})
+	})
+
+	Describe("polling healthy services", func() {
+		var (
+			data []byte
+			err  error
+		)
+
+		JustBeforeEach(func() {
+			data, err = hlth.Poll(ctx)
+		})
+
+		When("all is well", func() {
+			BeforeEach(func() {
+				hlth.Idx = 5
+			})
+
+			It("responds with encoded services and sets index", func() {
+				Expect(err).ToNot(HaveOccurred())
+
+				services, err := entity.DecodeServices(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(services).To(HaveLen(2))
+				Expect(hlth.Idx).To(BeEquivalentTo(53))
+			})
+		})
+	})
+})
@@ -0,0 +1,45 @@
+package discover
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff is an exponential backoff policy with jitter.
+//
+// The zero value does not back off at all.
+type Backoff struct {
+	// Base is the delay after the first failure, doubling with each consecutive failure.
+	Base time.Duration
+	// Max caps the delay.
+	Max time.Duration
+	// Jitter is the fraction of the delay, from 0 to 1, randomly taken off.
+	Jitter float64
+}
+
+// Delay returns the delay due after a number of consecutive failures.
+func (bo Backoff) Delay(failures int) (delay time.Duration) {
+
+	if failures < 1 || bo.Base <= 0 {
+		return
+	}
+
+	delay = bo.Base
+	for i := 1; i < failures; i++ {
+		if delay > math.MaxInt64/2 || (bo.Max > 0 && delay >= bo.Max) {
+			break
+		}
+		delay *= 2
+	}
+	if bo.Max > 0 && delay > bo.Max {
+		delay = bo.Max
+	}
+
+	if bo.Jitter > 0 {
+		jitter := min(bo.Jitter, 1)
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+
+	return
+}
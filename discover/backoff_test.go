@@ -0,0 +1,59 @@
+package discover_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/discover"
+)
+
+var _ = Describe("Backoff", func() {
+
+	var (
+		bo Backoff
+	)
+
+	BeforeEach(func() {
+		bo = Backoff{
+			Base: time.Second,
+			Max:  time.Minute,
+		}
+	})
+
+	When("without jitter", func() {
+		It("doubles with each failure up to max", func() {
+			Expect(bo.Delay(0)).To(Equal(time.Duration(0)))
+			Expect(bo.Delay(1)).To(Equal(time.Second))
+			Expect(bo.Delay(2)).To(Equal(2 * time.Second))
+			Expect(bo.Delay(4)).To(Equal(8 * time.Second))
+			Expect(bo.Delay(7)).To(Equal(time.Minute))
+			Expect(bo.Delay(999)).To(Equal(time.Minute))
+		})
+	})
+
+	When("with jitter", func() {
+		BeforeEach(func() {
+			bo.Jitter = 0.5
+		})
+
+		It("takes off up to the fraction", func() {
+			for i := 0; i < 99; i++ {
+				delay := bo.Delay(4)
+				Expect(delay).To(BeNumerically(">=", 4*time.Second))
+				Expect(delay).To(BeNumerically("<=", 8*time.Second))
+			}
+		})
+	})
+
+	When("zero", func() {
+		BeforeEach(func() {
+			bo = Backoff{}
+		})
+
+		It("does not back off", func() {
+			Expect(bo.Delay(9)).To(Equal(time.Duration(0)))
+		})
+	})
+})
@@ -0,0 +1,167 @@
+package discover
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"configstate/entity"
+)
+
+const (
+	ejectFor time.Duration = 30 * time.Second
+)
+
+// Breaker is the state of a service's circuit breaker.
+//
+// Until is when an ejected service is back in selection, nil when never ejected.
+type Breaker struct {
+	Failures int        `json:"failures"`
+	Ejected  bool       `json:"ejected"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// Breakers is a registry of circuit breakers, one per service uri.
+//
+// Callers report the outcome of requests to services, and a service failing
+// Threshold times in a row is ejected from selection for Cooling.
+// Once cooled, a service is back in selection, with a single failure ejecting it again
+// and a single success closing its breaker.
+type Breakers struct {
+	Logger    Logger
+	Threshold int
+	// Cooling is how long a service is ejected, defaulting to thirty seconds.
+	Cooling  time.Duration
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+	changes  atomic.Int64
+	filtered atomic.Pointer[filtered]
+}
+
+// Report records the outcome of a request to the service at uri, nil err being a success.
+func (brk *Breakers) Report(ctx context.Context, uri string, err error) {
+
+	brk.mu.Lock()
+	defer brk.mu.Unlock()
+
+	if brk.breakers == nil {
+		brk.breakers = map[string]*Breaker{}
+	}
+
+	breaker, ok := brk.breakers[uri]
+	if !ok {
+		breaker = &Breaker{}
+		brk.breakers[uri] = breaker
+	}
+
+	now := time.Now()
+	if breaker.cooling(now) {
+		// outcomes of requests in flight when ejected are of no interest
+		return
+	}
+
+	if err == nil {
+		if breaker.Ejected {
+			brk.changes.Add(1)
+		}
+		*breaker = Breaker{}
+		return
+	}
+
+	breaker.Failures++
+	if !breaker.Ejected && breaker.Failures < brk.Threshold {
+		return
+	}
+
+	cooling := brk.Cooling
+	if cooling <= 0 {
+		cooling = ejectFor
+	}
+
+	until := now.Add(cooling)
+	breaker.Ejected = true
+	breaker.Until = &until
+	brk.changes.Add(1)
+
+	brk.Logger.Error(ctx, "service ejected", err, "uri", uri, "failures", breaker.Failures, "until", until)
+}
+
+// States returns the state of each breaker, by uri.
+func (brk *Breakers) States() (states map[string]Breaker) {
+
+	brk.mu.Lock()
+	defer brk.mu.Unlock()
+
+	states = make(map[string]Breaker, len(brk.breakers))
+	for uri, breaker := range brk.breakers {
+		states[uri] = *breaker
+	}
+
+	return
+}
+
+// Filter returns a view of services from source that are not ejected.
+//
+// Views are cached, so a view is only rebuilt when source or ejections have changed.
+func (brk *Breakers) Filter(source View) View {
+
+	now := time.Now()
+	changes := brk.changes.Load()
+
+	current := brk.filtered.Load()
+	if current != nil && current.source.same(source) && current.changes == changes && now.Before(current.expires) {
+		return current.view
+	}
+
+	updated := &filtered{
+		source:  source,
+		changes: changes,
+		expires: now.Add(ejectFor),
+	}
+
+	brk.mu.Lock()
+	services := entity.Services{}
+	for i := 0; i < source.Len(); i++ {
+		breaker, ok := brk.breakers[source.Uri(i)]
+		if ok && breaker.cooling(now) {
+			if breaker.Until.Before(updated.expires) {
+				updated.expires = *breaker.Until
+			}
+			continue
+		}
+		services = append(services, source.services[i])
+	}
+	brk.mu.Unlock()
+
+	updated.view = View{services: services}
+	brk.filtered.Store(updated)
+
+	return updated.view
+}
+
+// unexported
+
+// cooling is true while ejected and not yet back in selection.
+func (breaker *Breaker) cooling(now time.Time) bool {
+
+	return breaker.Ejected && breaker.Until != nil && now.Before(*breaker.Until)
+}
+
+// retain drops breakers for services no longer published.
+func (brk *Breakers) retain(services entity.Services) {
+
+	brk.mu.Lock()
+	defer brk.mu.Unlock()
+
+	uris := map[string]bool{}
+	for _, svc := range services {
+		uris[svc.Uri] = true
+	}
+
+	for uri := range brk.breakers {
+		if !uris[uri] {
+			delete(brk.breakers, uri)
+		}
+	}
+}
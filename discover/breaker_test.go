@@ -0,0 +1,157 @@
+package discover_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	. "configstate/discover"
+	"configstate/discover/mock"
+)
+
+var _ = Describe("Breakers", func() {
+
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		wg      sync.WaitGroup
+		dsc     *Discover
+		bad     string
+		boom    error
+		healthy func() []string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		bad = "http://pool01.boxworld.org/api/v2"
+		boom = errors.New("boom")
+
+		data, err := json.Marshal(services(3))
+		Expect(err).ToNot(HaveOccurred())
+
+		dsc = &Discover{
+			Logger: &mock.LoggerMock{
+				ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+				InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+				WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+					return ctx
+				},
+			},
+			Poller:       once(data),
+			ReadyTimeout: time.Second,
+			Breakers: &Breakers{
+				Threshold: 2,
+				Cooling:   50 * time.Millisecond,
+			},
+		}
+
+		err = dsc.Start(ctx, &wg)
+		Expect(err).ToNot(HaveOccurred())
+
+		healthy = func() (uris []string) {
+			vw := dsc.Healthy()
+			for i := 0; i < vw.Len(); i++ {
+				uris = append(uris, vw.Uri(i))
+			}
+			return
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	When("failures are below threshold", func() {
+		BeforeEach(func() {
+			dsc.Report(ctx, bad, boom)
+		})
+
+		It("keeps the service in selection", func() {
+			Expect(healthy()).To(ContainElement(bad))
+			Expect(dsc.Breakers.States()[bad].Failures).To(Equal(1))
+
+			data, err := json.Marshal(dsc.Breakers.States()[bad])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(MatchJSON(`{"failures":1,"ejected":false}`))
+		})
+
+		It("resets on success", func() {
+			dsc.Report(ctx, bad, nil)
+			dsc.Report(ctx, bad, boom)
+			Expect(healthy()).To(ContainElement(bad))
+		})
+	})
+
+	When("failures reach threshold", func() {
+		BeforeEach(func() {
+			dsc.Report(ctx, bad, boom)
+			dsc.Report(ctx, bad, boom)
+		})
+
+		It("ejects the service for the cooling period", func() {
+			Expect(healthy()).To(HaveLen(2))
+			Expect(healthy()).ToNot(ContainElement(bad))
+			Expect(dsc.Breakers.States()[bad].Ejected).To(BeTrue())
+			Expect(*dsc.Breakers.States()[bad].Until).To(BeTemporally("~", time.Now().Add(50*time.Millisecond), 50*time.Millisecond))
+
+			sel := dsc.Selector("resize")
+			for i := 0; i < 4; i++ {
+				svc, err := sel.Select()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(svc.Uri).ToNot(Equal(bad))
+			}
+
+			Eventually(healthy).Should(ContainElement(bad))
+		})
+
+		It("ignores outcomes reported while cooling", func() {
+			dsc.Report(ctx, bad, nil)
+			Expect(healthy()).ToNot(ContainElement(bad))
+		})
+
+		It("ejects again on a single failure once cooled", func() {
+			Eventually(healthy).Should(ContainElement(bad))
+
+			dsc.Report(ctx, bad, boom)
+			Expect(healthy()).ToNot(ContainElement(bad))
+		})
+
+		It("closes on a single success once cooled", func() {
+			Eventually(healthy).Should(ContainElement(bad))
+
+			dsc.Report(ctx, bad, nil)
+			dsc.Report(ctx, bad, boom)
+			Expect(healthy()).To(ContainElement(bad))
+			Expect(dsc.Breakers.States()[bad].Ejected).To(BeFalse())
+		})
+	})
+
+	When("reporting on an unknown service", func() {
+		It("is ignored", func() {
+			dsc.Report(ctx, "http://pool42.boxworld.org/api/v2", boom)
+			Expect(dsc.Breakers.States()).To(BeEmpty())
+		})
+	})
+
+	When("a service is no longer published", func() {
+		BeforeEach(func() {
+			dsc.Report(ctx, bad, boom)
+
+			data, err := json.Marshal(services(1))
+			Expect(err).ToNot(HaveOccurred())
+
+			err = dsc.SetPoller(ctx, once(data))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("drops its breaker", func() {
+			Eventually(dsc.Breakers.States).Should(BeEmpty())
+		})
+	})
+})
@@ -3,19 +3,27 @@ package discover
 import (
 	"context"
 	"fmt"
-	"hash"
 	"hash/fnv"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/clarktrimble/delish/respond"
-	"github.com/clarktrimble/hondo"
 	"github.com/pkg/errors"
 
 	"configstate/entity"
 )
 
-//go:generate moq -pkg mock -out mock/mock.go . Logger Poller
+const (
+	waitDefault time.Duration = 5 * time.Minute
+	waitMax     time.Duration = 10 * time.Minute
+	writeSlack  time.Duration = 10 * time.Second
+	indexHeader string        = "X-Discover-Index"
+)
+
+//go:generate moq -pkg mock -out mock/mock.go . Logger Poller Router
 
 // Logger specifies a logger.
 type Logger interface {
@@ -35,102 +43,522 @@ type Router interface {
 	Set(method, path string, handler http.HandlerFunc)
 }
 
+// Config is Discover configuration.
+type Config struct {
+	SnapshotPath  string        `json:"snapshot_path" desc:"file in which to keep last-known-good services, none when empty"`
+	ReadyTimeout  time.Duration `json:"ready_timeout" desc:"wait this long for services on start, no wait when zero"`
+	BackoffBase   time.Duration `json:"backoff_base" desc:"delay after first failure to poll, doubling thereafter" default:"1s"`
+	BackoffMax    time.Duration `json:"backoff_max" desc:"maximum delay after failure to poll" default:"1m"`
+	BackoffJitter float64       `json:"backoff_jitter" desc:"fraction of backoff delay randomly taken off" default:"0.2"`
+	HealthPath    string        `json:"health_path" desc:"path probed on each service to check its health, no checks when empty"`
+	HealthEvery   time.Duration `json:"health_every" desc:"interval between health checks" default:"10s"`
+	HealthTimeout time.Duration `json:"health_timeout" desc:"timeout for each health probe" default:"2s"`
+	HealthRise    int           `json:"health_rise" desc:"consecutive successes for a service to be up" default:"2"`
+	HealthFall    int           `json:"health_fall" desc:"consecutive failures for a service to be down" default:"3"`
+	EjectAfter    int           `json:"eject_after" desc:"consecutive reported failures for a service to be ejected, no ejection when zero"`
+	EjectFor      time.Duration `json:"eject_for" desc:"how long an ejected service is kept out of selection" default:"30s"`
+	MaxWait       time.Duration `json:"max_wait" desc:"cap on blocking query wait, best kept within server write timeout" default:"1m"`
+	Heartbeat     time.Duration `json:"heartbeat" desc:"interval between heartbeats on event streams" default:"15s"`
+}
+
 // Discover polls for available services.
 type Discover struct {
-	Logger   Logger
-	Poller   Poller
-	services entity.Services
+	Logger       Logger
+	Poller       Poller
+	SnapshotPath string
+	ReadyTimeout time.Duration
+	Backoff      Backoff
+	// MaxWait caps the wait of blocking queries, defaulting to ten minutes.
+	MaxWait time.Duration
+	// Heartbeat is the interval between heartbeats on event streams, defaulting to fifteen seconds.
+	Heartbeat time.Duration
+	// Checker, when not nil, is started with Discover, filtering out unhealthy services.
+	Checker *Checker
+	// Breakers, when not nil, ejects services failing as reported.
+	Breakers *Breakers
+	dynafig  Dynafig[entity.Services]
+	setup    sync.Once
 	mu       sync.RWMutex
-	hash     hash.Hash
-	sum      string
+	diff     entity.Diff
+	ctx      context.Context
+}
+
+// New creates a Discover from Config.
+func (cfg *Config) New(lgr Logger, poller Poller) *Discover {
+
+	dsc := &Discover{
+		Logger:       lgr,
+		Poller:       poller,
+		SnapshotPath: cfg.SnapshotPath,
+		ReadyTimeout: cfg.ReadyTimeout,
+		MaxWait:      cfg.MaxWait,
+		Heartbeat:    cfg.Heartbeat,
+		Backoff: Backoff{
+			Base:   cfg.BackoffBase,
+			Max:    cfg.BackoffMax,
+			Jitter: cfg.BackoffJitter,
+		},
+	}
+
+	if cfg.HealthPath != "" {
+		dsc.Checker = &Checker{
+			Logger:   lgr,
+			Path:     cfg.HealthPath,
+			Interval: cfg.HealthEvery,
+			Timeout:  cfg.HealthTimeout,
+			Rise:     cfg.HealthRise,
+			Fall:     cfg.HealthFall,
+		}
+	}
+
+	if cfg.EjectAfter > 0 {
+		dsc.Breakers = &Breakers{
+			Logger:    lgr,
+			Threshold: cfg.EjectAfter,
+			Cooling:   cfg.EjectFor,
+		}
+	}
+
+	return dsc
 }
 
 // Services returns a copy of available services.
 func (dsc *Discover) Services() entity.Services {
 
+	return dsc.dynafig.Value().Copy()
+}
+
+// View returns a read-only view of available services, without locking or copying.
+func (dsc *Discover) View() View {
+
+	return View{services: dsc.dynafig.Value()}
+}
+
+// Healthy returns a read-only view of available services passing health checks and not ejected.
+//
+// When there is no Checker or Breakers, all available services are included.
+func (dsc *Discover) Healthy() View {
+
+	return dsc.healthy(dsc.View())
+}
+
+// Report records the outcome of a request to the service at uri, nil err being a success.
+//
+// Reports are ignored when there are no Breakers or uri is not among available services.
+func (dsc *Discover) Report(ctx context.Context, uri string, err error) {
+
+	if dsc.Breakers == nil {
+		return
+	}
+
+	_, ok := dsc.View().Index(uri)
+	if !ok {
+		return
+	}
+
+	dsc.Breakers.Report(ctx, uri, err)
+}
+
+// Health returns the health of each service by uri, nil when there is no Checker.
+func (dsc *Discover) Health() map[string]Health {
+
+	if dsc.Checker == nil {
+		return nil
+	}
+
+	return dsc.Checker.Health()
+}
+
+// Stale is true when services were restored from snapshot and have yet to be confirmed by a poll.
+func (dsc *Discover) Stale() bool {
+
+	return dsc.dynafig.Stale()
+}
+
+// Status returns a summary of the worker's health.
+func (dsc *Discover) Status() Status {
+
+	return dsc.dynafig.Status()
+}
+
+// Ready is true once services have loaded.
+func (dsc *Discover) Ready() bool {
+
+	return dsc.dynafig.Ready()
+}
+
+// WaitReady blocks until services have loaded, returning an error if ctx is done first.
+func (dsc *Discover) WaitReady(ctx context.Context) error {
+
+	return dsc.dynafig.WaitReady(ctx)
+}
+
+// Index returns the number of times services have been published, increasing with each update.
+func (dsc *Discover) Index() uint64 {
+
+	return dsc.dynafig.Index()
+}
+
+// WaitIndex blocks until the index differs from index, or ctx is done, returning the current index.
+func (dsc *Discover) WaitIndex(ctx context.Context, index uint64) uint64 {
+
+	return dsc.dynafig.WaitIndex(ctx, index)
+}
+
+// Diff returns the difference found on the most recent update of services.
+func (dsc *Discover) Diff() entity.Diff {
+
 	dsc.mu.RLock()
 	defer dsc.mu.RUnlock()
 
-	return dsc.services.Copy()
+	return dsc.diff
 }
 
-// Start starts the poll worker.
-func (dsc *Discover) Start(ctx context.Context, wg *sync.WaitGroup) {
+// Subscribe returns a channel on which changes to services are delivered and a func to unsubscribe.
+//
+// See Dynafig.Subscribe for buffering and slow subscriber handling.
+func (dsc *Discover) Subscribe(size int) (changes <-chan Change[entity.Services], unsubscribe func()) {
 
-	// Todo: don't start more than once!!!
+	return dsc.dynafig.Subscribe(size)
+}
 
-	ctx = dsc.Logger.WithFields(ctx, "worker_id", hondo.Rand(7))
-	dsc.Logger.Info(ctx, "worker starting", "name", "discovery")
+// OnChange registers a callback to be called by the worker after each update of services.
+func (dsc *Discover) OnChange(callback func(ctx context.Context, old, updated entity.Services)) {
 
-	go dsc.work(ctx, wg)
+	dsc.dynafig.OnChange(callback)
+}
+
+// Start starts the poll worker, returning an error if already started.
+//
+// When ReadyTimeout is not zero, Start waits for services to load,
+// returning an error if they have not by the deadline.
+func (dsc *Discover) Start(ctx context.Context, wg *sync.WaitGroup) (err error) {
+
+	dsc.setup.Do(func() {
+		dsc.dynafig.Logger = dsc.Logger
+		dsc.dynafig.Poller = dsc.Poller
+		dsc.dynafig.Decode = entity.DecodeServices
+		dsc.dynafig.Name = "services"
+		dsc.dynafig.SnapshotPath = dsc.SnapshotPath
+		dsc.dynafig.Backoff = dsc.Backoff
+		dsc.dynafig.OnChange(dsc.changed)
+		if dsc.Checker != nil && dsc.Checker.Logger == nil {
+			dsc.Checker.Logger = dsc.Logger
+		}
+		if dsc.Breakers != nil && dsc.Breakers.Logger == nil {
+			dsc.Breakers.Logger = dsc.Logger
+		}
+	})
+
+	err = dsc.dynafig.Start(ctx, wg)
+	if err != nil {
+		return
+	}
+
+	dsc.mu.Lock()
+	dsc.ctx = ctx
+	dsc.mu.Unlock()
+
+	if dsc.Checker != nil {
+		dsc.Checker.start(ctx, wg, dsc.View)
+	}
+
+	if dsc.ReadyTimeout == 0 {
+		return
+	}
+
+	readyCtx, cancel := context.WithTimeout(ctx, dsc.ReadyTimeout)
+	defer cancel()
+
+	err = dsc.WaitReady(readyCtx)
+	return
+}
+
+// Stop stops the poll worker and health checker and waits for them, returning an error if ctx is done first.
+func (dsc *Discover) Stop(ctx context.Context) (err error) {
+
+	err = dsc.dynafig.Stop(ctx)
+	if err != nil || dsc.Checker == nil {
+		return
+	}
+
+	err = dsc.Checker.stop(ctx)
+	return
+}
+
+// SetPoller swaps in a new Poller, restarting the worker if it is running.
+//
+// Services are kept until the new Poller turns up something different.
+func (dsc *Discover) SetPoller(ctx context.Context, poller Poller) (err error) {
+
+	err = dsc.dynafig.SetPoller(ctx, poller)
+	if err != nil {
+		return
+	}
+
+	dsc.Poller = poller
+	return
 }
 
 // Register registers routes with the router.
+//
+// GET /services responds with healthy services, optionally filtered by "capability" and "min_capacity".
+// Both respond with an ETag and honor If-None-Match, responding 304 when content is unchanged.
+//
+// GET /services also supports Consul-style blocking queries.
+// The X-Discover-Index header carries the current index and, given "index" of the same value,
+// a request blocks until services are updated or "wait" elapses, defaulting to 5m and capped at MaxWait.
+// Only updates to published services unblock, not changes in health.
+//
+// GET /services/stream responds with server-sent events, "services" on connect and on each update,
+// or "diff" on each update given "diff=true", ending with "shutdown" when the ctx passed to Start is done.
+// GET /services/{uri-or-host} responds with a single available service, healthy or not.
 func (dsc *Discover) Register(rtr Router) {
 
 	rtr.Set("GET", "/services", dsc.getServices)
+	rtr.Set("GET", "/services/stream", dsc.getStream)
+	rtr.Set("GET", "/services/*", dsc.getService)
+	rtr.Set("GET", "/ready", dsc.getReady)
+	rtr.Set("GET", "/status", dsc.getStatus)
 }
 
 // unexported
 
-func (dsc *Discover) work(ctx context.Context, wg *sync.WaitGroup) {
+// healthy filters vw down to services passing health checks and not ejected.
+func (dsc *Discover) healthy(vw View) View {
 
-	wg.Add(1)
-	defer wg.Done()
+	if dsc.Checker != nil {
+		vw = dsc.Checker.Filter(vw)
+	}
+	if dsc.Breakers != nil {
+		vw = dsc.Breakers.Filter(vw)
+	}
 
-	dsc.hash = fnv.New64a()
+	return vw
+}
 
-	for {
+func (dsc *Discover) changed(ctx context.Context, old, updated entity.Services) {
 
-		data, err := dsc.Poller.Poll(ctx)
-		if errors.Is(err, context.Canceled) {
-			dsc.Logger.Info(ctx, "worker shutting down")
-			break
-		}
-		if err != nil {
-			dsc.Logger.Error(ctx, "failed to watch", err)
-			continue
-		}
-		if dsc.unchanged(data) {
-			continue
-		}
+	diff := old.Diff(updated)
+	dsc.Logger.Info(ctx, "services changed", "diff", diff)
 
-		services, err := entity.DecodeServices(data)
-		if err != nil {
-			dsc.Logger.Error(ctx, "failed to watch", err)
-			continue
-		}
+	dsc.mu.Lock()
+	dsc.diff = diff
+	dsc.mu.Unlock()
 
-		dsc.Logger.Info(ctx, "updating services")
+	if dsc.Breakers != nil {
+		dsc.Breakers.retain(updated)
+	}
+}
+
+func (dsc *Discover) getReady(writer http.ResponseWriter, request *http.Request) {
+
+	rp := &respond.Respond{
+		Writer: writer,
+		Logger: dsc.Logger,
+	}
 
-		dsc.mu.Lock()
-		dsc.services = services
-		dsc.mu.Unlock()
+	if !dsc.Ready() {
+		writer.Header().Set("content-type", "application/json")
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		rp.Write(request.Context(), []byte(`{"status":"not ready"}`))
+		return
 	}
 
-	dsc.Logger.Info(ctx, "worker stopped")
+	rp.Ok(request.Context())
 }
 
-func (dsc *Discover) unchanged(data []byte) bool {
+func (dsc *Discover) getStatus(writer http.ResponseWriter, request *http.Request) {
 
-	dsc.hash.Write(data)
-	newSum := fmt.Sprintf("%x", dsc.hash.Sum(nil))
-	dsc.hash.Reset()
-
-	if dsc.sum == newSum {
-		return true
+	rp := &respond.Respond{
+		Writer: writer,
+		Logger: dsc.Logger,
 	}
 
-	dsc.sum = newSum
-	return false
+	rp.WriteObjects(request.Context(), map[string]any{"status": dsc.Status()})
 }
 
 func (dsc *Discover) getServices(writer http.ResponseWriter, request *http.Request) {
 
+	ctx := request.Context()
 	rp := &respond.Respond{
 		Writer: writer,
 		Logger: dsc.Logger,
 	}
 
-	rp.WriteObjects(request.Context(), map[string]any{"services": dsc.Services()})
+	query := request.URL.Query()
+	capability := query.Get("capability")
+
+	minCapacity := 0
+	if query.Has("min_capacity") {
+		var err error
+		minCapacity, err = strconv.Atoi(query.Get("min_capacity"))
+		if err != nil || capability == "" {
+			err = errors.Errorf("min_capacity must be an integer and accompany capability")
+			rp.NotOk(ctx, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	index, err := dsc.block(writer, request)
+	if err != nil {
+		rp.NotOk(ctx, http.StatusBadRequest, err)
+		return
+	}
+	writer.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+
+	services, sum := dsc.dynafig.Current()
+	healthy := dsc.healthy(View{services: services})
+
+	objects := map[string]any{
+		"services": healthy.Services().Filter(capability, minCapacity),
+		"stale":    dsc.Stale(),
+	}
+	if dsc.Checker != nil {
+		objects["health"] = dsc.Health()
+	}
+	if dsc.Breakers != nil {
+		objects["breakers"] = dsc.Breakers.States()
+	}
+
+	etag := contentTag(sum, healthy, capability, strconv.Itoa(minCapacity))
+	writeTagged(rp, request, etag, objects)
+}
+
+func (dsc *Discover) getService(writer http.ResponseWriter, request *http.Request) {
+
+	ctx := request.Context()
+	rp := &respond.Respond{
+		Writer: writer,
+		Logger: dsc.Logger,
+	}
+
+	// key is taken from the path so as not to depend on the router's path params
+	// a uri will need to be escaped, "/services/http%3A%2F%2Fpool04.boxworld.org%2Fapi%2Fv2" for example
+
+	key := strings.TrimPrefix(request.URL.Path, "/services/")
+
+	services, sum := dsc.dynafig.Current()
+	vw := View{services: services}
+
+	svc, ok := vw.Services().Find(key)
+	if !ok {
+		rp.NotFound(ctx)
+		return
+	}
+
+	healthy := dsc.healthy(vw)
+	_, up := healthy.Index(svc.Uri)
+
+	objects := map[string]any{
+		"service": svc,
+		"healthy": up,
+		"stale":   dsc.Stale(),
+	}
+	if dsc.Checker != nil {
+		objects["health"] = dsc.Health()[svc.Uri]
+	}
+	if dsc.Breakers != nil {
+		objects["breaker"] = dsc.Breakers.States()[svc.Uri]
+	}
+
+	etag := contentTag(sum, healthy, key)
+	writeTagged(rp, request, etag, objects)
+}
+
+// block waits on index and wait query params when given, returning the current index.
+//
+// The server's write deadline is pushed out past the wait, as it may well be shorter,
+// with failure to do so logged.
+func (dsc *Discover) block(writer http.ResponseWriter, request *http.Request) (index uint64, err error) {
+
+	query := request.URL.Query()
+	if !query.Has("index") {
+		index = dsc.Index()
+		return
+	}
+
+	index, err = strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		err = errors.Errorf("index must be an unsigned integer")
+		return
+	}
+
+	wait := waitDefault
+	if query.Has("wait") {
+		wait, err = time.ParseDuration(query.Get("wait"))
+		if err != nil || wait < 0 {
+			err = errors.Errorf("wait must be a positive duration, such as 10s")
+			return
+		}
+	}
+	maxWait := waitMax
+	if dsc.MaxWait > 0 {
+		maxWait = dsc.MaxWait
+	}
+	wait = min(wait, maxWait)
+
+	// a buffered writer, as from response logging, does not support this
+	// see Unbuffered
+
+	rc := http.NewResponseController(writer)
+	err = rc.SetWriteDeadline(time.Now().Add(wait + writeSlack))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to extend write deadline for blocking query")
+		dsc.Logger.Error(request.Context(), "blocking query may be cut short by server write timeout", err, "wait", wait)
+		err = nil
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), wait)
+	defer cancel()
+
+	index = dsc.WaitIndex(ctx, index)
+	return
+}
+
+// contentTag is a weak etag of the content served, from the sum of services as published,
+// request params and the uris of healthy, non-ejected services.
+//
+// Being of content, rather than index, the tag holds across restarts and replicas.
+// Staleness and health and breaker detail, with its timestamps and counters, are left out,
+// so that the tag holds steady from one round of checks to the next, and so it's weak as per rfc 9110.
+func contentTag(sum string, healthy View, params ...string) string {
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s\n", sum)
+	for _, param := range params {
+		fmt.Fprintf(hash, "%s\n", param)
+	}
+	for i := 0; i < healthy.Len(); i++ {
+		fmt.Fprintf(hash, "%s\n", healthy.Uri(i))
+	}
+
+	return fmt.Sprintf(`W/"%x"`, hash.Sum(nil))
+}
+
+// writeTagged responds with objects and etag,
+// or with 304 not modified when etag matches the request's If-None-Match.
+func writeTagged(rp *respond.Respond, request *http.Request, etag string, objects map[string]any) {
+
+	rp.Writer.Header().Set("etag", etag)
+	if noneMatch(request.Header.Get("if-none-match"), etag) {
+		rp.Writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rp.WriteObjects(request.Context(), objects)
+}
+
+// noneMatch is true when an If-None-Match header value matches etag, weakly as per rfc 9110.
+func noneMatch(header, etag string) bool {
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
 }
@@ -2,14 +2,26 @@ package discover_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/clarktrimble/delish"
+	"github.com/clarktrimble/delish/mid"
+	"github.com/clarktrimble/hondo"
+	"github.com/pkg/errors"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"configstate/chi"
 	. "configstate/discover"
 	"configstate/discover/mock"
 	"configstate/entity"
@@ -98,6 +110,14 @@ var _ = Describe("Discover", func() {
 				expected[1].Caps[0].Capacity = 55
 
 				Eventually(dsc.Services).Should(Equal(expected))
+				Expect(dsc.Diff()).To(Equal(entity.Diff{
+					Added:   entity.Services{},
+					Removed: entity.Services{},
+					Modified: []entity.Modified{{
+						Uri:    "http://pool24.boxworld.org/api/v2",
+						Deltas: []entity.CapacityDelta{{Name: "resize", Old: 5, New: 55}},
+					}},
+				}))
 
 				cancel()
 				wg.Wait()
@@ -106,18 +126,52 @@ var _ = Describe("Discover", func() {
 				Expect(mockedLogger.WithFieldsCalls()[0].Kv[0]).To(Equal("worker_id"))
 
 				infoCalls := mockedLogger.InfoCalls()
-				Expect(infoCalls).To(HaveLen(5))
+				Expect(infoCalls).To(HaveLen(7))
 				Expect(infoCalls[0].Msg).To(Equal("worker starting"))
 				Expect(infoCalls[1].Msg).To(Equal("updating services"))
-				Expect(infoCalls[2].Msg).To(Equal("updating services"))
-				Expect(infoCalls[3].Msg).To(Equal("worker shutting down"))
-				Expect(infoCalls[4].Msg).To(Equal("worker stopped"))
+				Expect(infoCalls[2].Msg).To(Equal("services changed"))
+				Expect(infoCalls[2].Kv[1].(entity.Diff).Added).To(HaveLen(2))
+				Expect(infoCalls[3].Msg).To(Equal("updating services"))
+				Expect(infoCalls[4].Msg).To(Equal("services changed"))
+				Expect(infoCalls[5].Msg).To(Equal("worker shutting down"))
+				Expect(infoCalls[6].Msg).To(Equal("worker stopped"))
 
 				Expect(mockedLogger.ErrorCalls()).To(HaveLen(0))
 
 			}, SpecTimeout(time.Second))
 		})
 
+		When("callers modify services", func() {
+			BeforeEach(func() {
+				dsc.Start(ctx, &wg)
+			})
+
+			It("does not change what other callers see", func(ctx SpecContext) {
+
+				Eventually(dsc.Services).Should(Equal(expected))
+
+				var readers sync.WaitGroup
+				for i := 0; i < 9; i++ {
+					readers.Add(1)
+					go func(capacity int) {
+						defer readers.Done()
+
+						services := dsc.Services()
+						services[1].Caps[0].Capacity = capacity
+						services[1].Caps[0].Name = "crop"
+						services[0].Caps = append(services[0].Caps, entity.Capability{Name: "crop"})
+					}(i)
+				}
+				readers.Wait()
+
+				Expect(dsc.Services()).To(Equal(expected))
+
+				cancel()
+				wg.Wait()
+
+			}, SpecTimeout(time.Second))
+		})
+
 		When("worker has not started", func() {
 			It("returns empty services", func() {
 				Expect(dsc.Services()).To(Equal(entity.Services{}))
@@ -126,4 +180,450 @@ var _ = Describe("Discover", func() {
 
 	})
 
+	Describe("checking readiness", func() {
+
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+			wg     sync.WaitGroup
+
+			dsc      *Discover
+			poller   *mock.PollerMock
+			handlers map[string]http.HandlerFunc
+			up       chan struct{}
+			err      error
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			up = make(chan struct{})
+
+			poller = &mock.PollerMock{
+				PollFunc: func(ctx context.Context) ([]byte, error) {
+					select {
+					case <-up:
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					}
+					time.Sleep(time.Microsecond)
+					return []byte(`[{"uri":"http://pool04.boxworld.org/api/v2"}]`), nil
+				},
+			}
+
+			dsc = &Discover{
+				Logger: &mock.LoggerMock{
+					ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+					InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+					WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+						return ctx
+					},
+				},
+				Poller: poller,
+			}
+
+			handlers = map[string]http.HandlerFunc{}
+			dsc.Register(&mock.RouterMock{
+				SetFunc: func(method, path string, handler http.HandlerFunc) {
+					handlers[path] = handler
+				},
+			})
+		})
+
+		AfterEach(func() {
+			cancel()
+			wg.Wait()
+		})
+
+		getReady := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			handlers["/ready"](recorder, httptest.NewRequest("GET", "/ready", nil))
+			return recorder
+		}
+
+		When("services have not loaded", func() {
+			BeforeEach(func() {
+				err = dsc.Start(ctx, &wg)
+			})
+
+			It("reports not ready until they do", func(ctx SpecContext) {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(getReady().Code).To(Equal(http.StatusServiceUnavailable))
+
+				close(up)
+
+				Expect(dsc.WaitReady(ctx)).To(Succeed())
+				recorder := getReady()
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Body.String()).To(Equal(`{"status":"ok"}`))
+
+			}, SpecTimeout(time.Second))
+		})
+
+		When("ready timeout elapses", func() {
+			BeforeEach(func() {
+				dsc.ReadyTimeout = 10 * time.Millisecond
+				err = dsc.Start(ctx, &wg)
+			})
+
+			It("fails to start", func() {
+				Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+				Expect(dsc.Ready()).To(BeFalse())
+				Eventually(poller.PollCalls).ShouldNot(BeEmpty())
+			})
+		})
+
+		When("services load before ready timeout", func() {
+			BeforeEach(func() {
+				close(up)
+				dsc.ReadyTimeout = time.Second
+				err = dsc.Start(ctx, &wg)
+			})
+
+			It("starts and is ready", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(dsc.Ready()).To(BeTrue())
+				Expect(dsc.Services()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("managing the worker lifecycle", func() {
+
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+			wg     sync.WaitGroup
+
+			dsc *Discover
+		)
+
+		pollerOf := func(data string) *mock.PollerMock {
+			return &mock.PollerMock{
+				PollFunc: func(ctx context.Context) ([]byte, error) {
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					case <-time.After(time.Millisecond):
+					}
+					return []byte(data), nil
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+
+			dsc = &Discover{
+				Logger: &mock.LoggerMock{
+					ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+					InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+					WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+						return ctx
+					},
+				},
+				Poller: pollerOf(`[{"uri":"http://pool04.boxworld.org/api/v2"}]`),
+			}
+
+			Expect(dsc.Start(ctx, &wg)).To(Succeed())
+			Eventually(dsc.Services).Should(HaveLen(1))
+		})
+
+		AfterEach(func() {
+			cancel()
+			wg.Wait()
+		})
+
+		It("refuses to start twice", func() {
+			Expect(dsc.Start(ctx, &wg)).To(MatchError(ContainSubstring("already started")))
+		})
+
+		It("stops only this worker and can start again", func(specCtx SpecContext) {
+			Expect(dsc.Stop(specCtx)).To(Succeed())
+			Expect(ctx.Err()).ToNot(HaveOccurred())
+			Expect(dsc.Services()).To(HaveLen(1))
+
+			Expect(dsc.Stop(specCtx)).To(Succeed())
+			Expect(dsc.Start(ctx, &wg)).To(Succeed())
+		}, SpecTimeout(time.Second))
+
+		It("swaps in a new poller without restarting", func(specCtx SpecContext) {
+			poller := pollerOf(`[{"uri":"http://pool04.boxworld.org/api/v2"},{"uri":"http://pool24.boxworld.org/api/v2"}]`)
+
+			Expect(dsc.SetPoller(specCtx, poller)).To(Succeed())
+			Eventually(dsc.Services).Should(HaveLen(2))
+			Expect(dsc.Poller).To(Equal(poller))
+		}, SpecTimeout(time.Second))
+	})
+
+	Describe("querying services", func() {
+
+		var (
+			ctx      context.Context
+			cancel   context.CancelFunc
+			wg       sync.WaitGroup
+			dsc      *Discover
+			handlers map[string]http.HandlerFunc
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+
+			dsc = loaded(ctx, &wg, 3)
+
+			handlers = map[string]http.HandlerFunc{}
+			dsc.Register(&mock.RouterMock{
+				SetFunc: func(method, path string, handler http.HandlerFunc) {
+					handlers[path] = handler
+				},
+			})
+		})
+
+		AfterEach(func() {
+			cancel()
+			wg.Wait()
+		})
+
+		get := func(route, target string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			handlers[route](recorder, httptest.NewRequest("GET", target, nil))
+			return recorder
+		}
+
+		uris := func(recorder *httptest.ResponseRecorder) (uris []string) {
+			body := struct {
+				Services entity.Services `json:"services"`
+			}{}
+			err := json.Unmarshal(recorder.Body.Bytes(), &body)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, svc := range body.Services {
+				uris = append(uris, svc.Uri)
+			}
+			return
+		}
+
+		It("filters by capability and capacity", func() {
+			recorder := get("/services", "/services?capability=resize&min_capacity=2")
+			Expect(recorder.Code).To(Equal(200))
+			Expect(uris(recorder)).To(Equal([]string{"http://pool02.boxworld.org/api/v2"}))
+
+			recorder = get("/services", "/services?capability=resize")
+			Expect(uris(recorder)).To(HaveLen(3))
+
+			recorder = get("/services", "/services?capability=crop")
+			Expect(uris(recorder)).To(BeEmpty())
+		})
+
+		It("rejects a bad min_capacity", func() {
+			Expect(get("/services", "/services?capability=resize&min_capacity=lots").Code).To(Equal(400))
+			Expect(get("/services", "/services?min_capacity=2").Code).To(Equal(400))
+		})
+
+		It("looks up a service by uri or host", func() {
+			recorder := get("/services/*", "/services/"+url.PathEscape("http://pool01.boxworld.org/api/v2"))
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Body.String()).To(MatchJSON(`{
+				"service": {"uri":"http://pool01.boxworld.org/api/v2","capabilities":[{"name":"resize","capacity":1}]},
+				"healthy": true,
+				"stale": false
+			}`))
+
+			recorder = get("/services/*", "/services/pool02.boxworld.org")
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Body.String()).To(ContainSubstring("pool02"))
+		})
+
+		It("responds not found for an unknown service", func() {
+			Expect(get("/services/*", "/services/pool42.boxworld.org").Code).To(Equal(404))
+		})
+
+		Describe("blocking queries", func() {
+
+			It("responds with the index", func() {
+				Expect(get("/services", "/services").Header().Get("X-Discover-Index")).To(Equal("1"))
+			})
+
+			It("responds straight away when index differs", func() {
+				recorder := get("/services", "/services?index=0&wait=1m")
+				Expect(recorder.Code).To(Equal(200))
+				Expect(recorder.Header().Get("X-Discover-Index")).To(Equal("1"))
+			})
+
+			It("blocks until wait elapses", func() {
+				start := time.Now()
+				recorder := get("/services", "/services?index=1&wait=50ms")
+				Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+				Expect(recorder.Code).To(Equal(200))
+				Expect(recorder.Header().Get("X-Discover-Index")).To(Equal("1"))
+				Expect(uris(recorder)).To(HaveLen(3))
+			})
+
+			It("blocks until services are updated", func(ctx SpecContext) {
+				done := make(chan *httptest.ResponseRecorder)
+				go func() {
+					done <- get("/services", "/services?index=1&wait=1m")
+				}()
+				Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+				data, err := json.Marshal(services(2))
+				Expect(err).ToNot(HaveOccurred())
+				err = dsc.SetPoller(ctx, once(data))
+				Expect(err).ToNot(HaveOccurred())
+
+				var recorder *httptest.ResponseRecorder
+				Eventually(done).Should(Receive(&recorder))
+				Expect(recorder.Header().Get("X-Discover-Index")).To(Equal("2"))
+				Expect(uris(recorder)).To(HaveLen(2))
+			}, SpecTimeout(time.Second))
+
+			It("caps wait", func() {
+				dsc.MaxWait = 20 * time.Millisecond
+
+				start := time.Now()
+				Expect(get("/services", "/services?index=1").Code).To(Equal(200))
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			})
+
+			Describe("behind delish response logging", func() {
+				var (
+					lgr    *mock.LoggerMock
+					server *httptest.Server
+				)
+
+				serve := func(handler http.Handler) {
+					server = httptest.NewUnstartedServer(handler)
+					server.Config.WriteTimeout = 20 * time.Millisecond
+					server.Start()
+				}
+
+				poll := func() (response *http.Response, err error) {
+					response, err = http.Get(server.URL + "/services?index=1&wait=100ms")
+					if err == nil {
+						_, err = io.ReadAll(response.Body)
+						response.Body.Close()
+					}
+					return
+				}
+
+				BeforeEach(func() {
+					lgr = dsc.Logger.(*mock.LoggerMock)
+				})
+
+				AfterEach(func() {
+					server.Close()
+				})
+
+				It("outlasts a shorter server write timeout when unbuffered", func() {
+					rtr := chi.New()
+					dsc.Register(rtr)
+					svr := (&delish.Config{Timeout: time.Second}).NewWithLog(ctx, rtr, lgr)
+					serve(Unbuffered(svr.Handler, mid.ReplaceCtx(ctx, mid.LogRequest(lgr, hondo.Rand, rtr))))
+
+					response, err := poll()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(response.StatusCode).To(Equal(200))
+					Expect(lgr.ErrorCalls()).To(BeEmpty())
+				})
+
+				It("logs failure to extend the write deadline when buffered", func() {
+					rtr := chi.New()
+					dsc.Register(rtr)
+					serve((&delish.Config{Timeout: time.Second}).NewWithLog(ctx, rtr, lgr).Handler)
+
+					_, err := poll()
+					Expect(err).To(HaveOccurred())
+					Expect(lgr.ErrorCalls()).ToNot(BeEmpty())
+					Expect(lgr.ErrorCalls()[0].Err).To(MatchError(ContainSubstring("failed to extend write deadline")))
+				})
+			})
+
+			It("rejects a bad index or wait", func() {
+				Expect(get("/services", "/services?index=-1").Code).To(Equal(400))
+				Expect(get("/services", "/services?index=1&wait=forever").Code).To(Equal(400))
+			})
+		})
+
+		Describe("conditional gets", func() {
+
+			getIf := func(etag string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest("GET", "/services", nil)
+				request.Header.Set("If-None-Match", etag)
+				handlers["/services"](recorder, request)
+				return recorder
+			}
+
+			It("tags services, responding not modified until they change", func() {
+				etag := get("/services", "/services").Header().Get("etag")
+				Expect(etag).To(MatchRegexp(`^W/"[0-9a-f]{16}"$`))
+				Expect(get("/services", "/services").Header().Get("etag")).To(Equal(etag))
+
+				recorder := getIf(etag)
+				Expect(recorder.Code).To(Equal(304))
+				Expect(recorder.Body.Len()).To(BeZero())
+				Expect(recorder.Header().Get("etag")).To(Equal(etag))
+
+				Expect(getIf(`"abc", ` + strings.TrimPrefix(etag, "W/")).Code).To(Equal(304))
+				Expect(getIf(`"abc"`).Code).To(Equal(200))
+
+				data, err := json.Marshal(services(2))
+				Expect(err).ToNot(HaveOccurred())
+				err = dsc.SetPoller(ctx, once(data))
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(func() int { return dsc.View().Len() }).Should(Equal(2))
+
+				recorder = getIf(etag)
+				Expect(recorder.Code).To(Equal(200))
+				Expect(recorder.Header().Get("etag")).ToNot(Equal(etag))
+				Expect(uris(recorder)).To(HaveLen(2))
+			})
+
+			It("tags by content, not index", func() {
+				etag := get("/services", "/services").Header().Get("etag")
+
+				// back and forth to the same content at a later index
+
+				for _, count := range []int{2, 3} {
+					data, err := json.Marshal(services(count))
+					Expect(err).ToNot(HaveOccurred())
+					err = dsc.SetPoller(ctx, once(data))
+					Expect(err).ToNot(HaveOccurred())
+					Eventually(func() int { return dsc.View().Len() }).Should(Equal(count))
+				}
+				Expect(dsc.Index()).To(BeEquivalentTo(3))
+				Expect(get("/services", "/services").Header().Get("etag")).To(Equal(etag))
+
+				// as would a replica
+
+				replica := loaded(ctx, &wg, 3)
+				replica.Register(&mock.RouterMock{
+					SetFunc: func(method, path string, handler http.HandlerFunc) {
+						if path == "/services" {
+							recorder := httptest.NewRecorder()
+							handler(recorder, httptest.NewRequest("GET", "/services", nil))
+							Expect(recorder.Header().Get("etag")).To(Equal(etag))
+						}
+					},
+				})
+
+				// capacity changes under the same uris
+
+				changed := services(3)
+				changed[1].Caps[0].Capacity = 11
+				data, err := json.Marshal(changed)
+				Expect(err).ToNot(HaveOccurred())
+				err = dsc.SetPoller(ctx, once(data))
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(dsc.Index).Should(BeEquivalentTo(4))
+
+				Expect(getIf(etag).Code).To(Equal(200))
+			})
+
+			It("tags differently filtered services differently", func() {
+				Expect(get("/services", "/services?capability=resize&min_capacity=2").Header().Get("etag")).
+					ToNot(Equal(get("/services", "/services").Header().Get("etag")))
+			})
+		})
+	})
+
 })
@@ -0,0 +1,561 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clarktrimble/hondo"
+	"github.com/pkg/errors"
+)
+
+// Decoder specifies a decoder, turning polled data into a value.
+type Decoder[T any] func(data []byte) (value T, err error)
+
+// Syncer is optionally implemented by a Poller,
+// signaling that initial state has loaded even when there is no data to be polled.
+type Syncer interface {
+	Synced() <-chan struct{}
+}
+
+// Change is a pair of values, before and after an update.
+type Change[T any] struct {
+	Old T
+	New T
+}
+
+// Status is a summary of the worker's health.
+type Status struct {
+	Ready    bool   `json:"ready"`
+	Stale    bool   `json:"stale"`
+	Failures int    `json:"failures"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Dynafig polls for dynamic configuration of any type.
+type Dynafig[T any] struct {
+	Logger Logger
+	Poller Poller
+	Decode Decoder[T]
+	// Name appears in log messages, "services" for example.
+	Name string
+	// SnapshotPath, when not empty, is where a last-known-good snapshot is kept.
+	SnapshotPath string
+	// Backoff is applied after consecutive failures to poll or decode.
+	Backoff    Backoff
+	value      atomic.Pointer[T]
+	index      uint64
+	published  chan struct{}
+	stale      bool
+	failures   int
+	lastErr    error
+	mu         sync.RWMutex
+	hash       hash.Hash
+	sum        string
+	valueSum   string
+	failed     bool
+	readyOnce  sync.Once
+	readyClose sync.Once
+	ready      chan struct{}
+	subMu      sync.Mutex
+	subs       []chan Change[T]
+	callbacks  []func(ctx context.Context, old, updated T)
+	lifeMu     sync.Mutex
+	parent     context.Context
+	wg         *sync.WaitGroup
+	cancel     context.CancelFunc
+	workers    sync.WaitGroup
+}
+
+// Value returns the current value, without locking or copying.
+//
+// Each update publishes a newly decoded value, so a value once returned is never changed by Dynafig.
+// It is shared with other callers however, and so should not be modified.
+func (dfg *Dynafig[T]) Value() (value T) {
+
+	current := dfg.value.Load()
+	if current == nil {
+		return
+	}
+
+	return *current
+}
+
+// Current returns value along with a sum of the data it was decoded from, as published together.
+//
+// Unlike the index, the sum is of content and so holds across restarts and replicas.
+func (dfg *Dynafig[T]) Current() (value T, sum string) {
+
+	dfg.mu.RLock()
+	defer dfg.mu.RUnlock()
+
+	return dfg.Value(), dfg.valueSum
+}
+
+// Stale is true when value was restored from snapshot and has yet to be confirmed by a poll.
+func (dfg *Dynafig[T]) Stale() bool {
+
+	dfg.mu.RLock()
+	defer dfg.mu.RUnlock()
+
+	return dfg.stale
+}
+
+// Status returns a summary of the worker's health.
+func (dfg *Dynafig[T]) Status() (status Status) {
+
+	dfg.mu.RLock()
+	defer dfg.mu.RUnlock()
+
+	status = Status{
+		Ready:    dfg.Ready(),
+		Stale:    dfg.stale,
+		Failures: dfg.failures,
+	}
+	if dfg.lastErr != nil {
+		status.Error = dfg.lastErr.Error()
+	}
+
+	return
+}
+
+// Ready is true once a value has been decoded, either polled or restored from snapshot,
+// or the Poller has synced.
+func (dfg *Dynafig[T]) Ready() bool {
+
+	select {
+	case <-dfg.readyChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitReady blocks until ready, returning an error if ctx is done first.
+func (dfg *Dynafig[T]) WaitReady(ctx context.Context) (err error) {
+
+	select {
+	case <-dfg.readyChan():
+		return
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(), "%s not ready", dfg.Name)
+		return
+	}
+}
+
+// Index returns the number of values published, increasing with each update.
+func (dfg *Dynafig[T]) Index() uint64 {
+
+	dfg.mu.RLock()
+	defer dfg.mu.RUnlock()
+
+	return dfg.index
+}
+
+// WaitIndex blocks until the index differs from index, or ctx is done, returning the current index.
+func (dfg *Dynafig[T]) WaitIndex(ctx context.Context, index uint64) (current uint64) {
+
+	dfg.mu.Lock()
+	current = dfg.index
+	if dfg.published == nil {
+		dfg.published = make(chan struct{})
+	}
+	published := dfg.published
+	dfg.mu.Unlock()
+
+	if current != index {
+		return
+	}
+
+	select {
+	case <-published:
+	case <-ctx.Done():
+	}
+
+	return dfg.Index()
+}
+
+// Subscribe returns a channel on which changes are delivered and a func to unsubscribe.
+//
+// Changes are buffered up to size, which is at least one.
+// When the buffer is full the oldest change is dropped in favor of the newest,
+// so a slow subscriber sees the latest value without holding up the worker.
+// Unsubscribe closes the channel.
+func (dfg *Dynafig[T]) Subscribe(size int) (changes <-chan Change[T], unsubscribe func()) {
+
+	if size < 1 {
+		size = 1
+	}
+	sub := make(chan Change[T], size)
+
+	dfg.subMu.Lock()
+	dfg.subs = append(dfg.subs, sub)
+	dfg.subMu.Unlock()
+
+	unsubscribe = func() {
+		dfg.subMu.Lock()
+		defer dfg.subMu.Unlock()
+
+		for i := range dfg.subs {
+			if dfg.subs[i] == sub {
+				dfg.subs = append(dfg.subs[:i], dfg.subs[i+1:]...)
+				close(sub)
+				return
+			}
+		}
+	}
+
+	changes = sub
+	return
+}
+
+// OnChange registers a callback to be called by the worker after each update.
+//
+// Callbacks are called in order of registration and should not block.
+// They may call Subscribe, OnChange or an unsubscribe, with a callback registered
+// from within a callback first called on the next update.
+func (dfg *Dynafig[T]) OnChange(callback func(ctx context.Context, old, updated T)) {
+
+	dfg.subMu.Lock()
+	defer dfg.subMu.Unlock()
+
+	dfg.callbacks = append(dfg.callbacks, callback)
+}
+
+// Start starts the poll worker, returning an error if already started.
+//
+// The worker stops when ctx is cancelled or on Stop, after which it can be started again.
+func (dfg *Dynafig[T]) Start(ctx context.Context, wg *sync.WaitGroup) (err error) {
+
+	dfg.lifeMu.Lock()
+	defer dfg.lifeMu.Unlock()
+
+	if dfg.cancel != nil {
+		err = errors.Errorf("%s worker already started", dfg.Name)
+		return
+	}
+
+	dfg.parent = ctx
+	dfg.wg = wg
+	dfg.start()
+
+	return
+}
+
+// Stop cancels the poll worker and waits for it to finish, returning an error if ctx is done first.
+//
+// Stop is a no-op when the worker has not been started.
+func (dfg *Dynafig[T]) Stop(ctx context.Context) (err error) {
+
+	dfg.lifeMu.Lock()
+	defer dfg.lifeMu.Unlock()
+
+	return dfg.stop(ctx)
+}
+
+// SetPoller swaps in a new Poller, restarting the worker if it is running.
+//
+// ctx bounds the wait for the running worker to stop.
+func (dfg *Dynafig[T]) SetPoller(ctx context.Context, poller Poller) (err error) {
+
+	dfg.lifeMu.Lock()
+	defer dfg.lifeMu.Unlock()
+
+	running := dfg.cancel != nil
+
+	err = dfg.stop(ctx)
+	if err != nil {
+		return
+	}
+
+	dfg.Poller = poller
+
+	if running {
+		dfg.start()
+	}
+
+	return
+}
+
+// unexported
+
+func (dfg *Dynafig[T]) start() {
+
+	// lifeMu is expected to be held
+
+	ctx, cancel := context.WithCancel(dfg.parent)
+	dfg.cancel = cancel
+
+	ctx = dfg.Logger.WithFields(ctx, "worker_id", hondo.Rand(7))
+	dfg.Logger.Info(ctx, "worker starting", "name", dfg.Name)
+
+	if dfg.hash == nil {
+		dfg.hash = fnv.New64a()
+		dfg.restore(ctx)
+	}
+
+	syncer, ok := dfg.Poller.(Syncer)
+	if ok {
+		dfg.wg.Add(1)
+		dfg.workers.Add(1)
+		go dfg.waitSynced(ctx, dfg.wg, syncer)
+	}
+
+	dfg.wg.Add(1)
+	dfg.workers.Add(1)
+	go dfg.work(ctx, dfg.wg)
+}
+
+func (dfg *Dynafig[T]) stop(ctx context.Context) (err error) {
+
+	// lifeMu is expected to be held
+
+	if dfg.cancel == nil {
+		return
+	}
+	dfg.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		dfg.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		dfg.cancel = nil
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(), "%s worker did not stop", dfg.Name)
+	}
+
+	return
+}
+
+func (dfg *Dynafig[T]) work(ctx context.Context, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	defer dfg.workers.Done()
+
+	for {
+
+		data, err := dfg.Poller.Poll(ctx)
+		if errors.Is(err, context.Canceled) {
+			dfg.Logger.Info(ctx, "worker shutting down")
+			break
+		}
+		if err != nil {
+			dfg.fail(ctx, err)
+			continue
+		}
+		if dfg.unchanged(data) {
+			if !dfg.failed {
+				dfg.confirm()
+			}
+			continue
+		}
+
+		value, err := dfg.Decode(data)
+		if err != nil {
+			dfg.failed = true
+			dfg.fail(ctx, err)
+			continue
+		}
+		dfg.failed = false
+
+		dfg.Logger.Info(ctx, fmt.Sprintf("updating %s", dfg.Name))
+
+		old := dfg.Value()
+		dfg.publish(&value, dfg.sum)
+
+		dfg.mu.Lock()
+		dfg.stale = false
+		dfg.failures = 0
+		dfg.lastErr = nil
+		dfg.mu.Unlock()
+
+		dfg.save(ctx, data)
+		dfg.markReady()
+		dfg.notify(ctx, old, value)
+	}
+
+	dfg.Logger.Info(ctx, "worker stopped")
+}
+
+func (dfg *Dynafig[T]) readyChan() chan struct{} {
+
+	dfg.readyOnce.Do(func() {
+		dfg.ready = make(chan struct{})
+	})
+
+	return dfg.ready
+}
+
+func (dfg *Dynafig[T]) markReady() {
+
+	ready := dfg.readyChan()
+
+	dfg.readyClose.Do(func() {
+		close(ready)
+	})
+}
+
+func (dfg *Dynafig[T]) waitSynced(ctx context.Context, wg *sync.WaitGroup, syncer Syncer) {
+
+	defer wg.Done()
+	defer dfg.workers.Done()
+
+	select {
+	case <-syncer.Synced():
+		dfg.Logger.Info(ctx, "poller synced", "name", dfg.Name)
+		dfg.markReady()
+	case <-ctx.Done():
+	}
+}
+
+func (dfg *Dynafig[T]) publish(value *T, sum string) {
+
+	dfg.mu.Lock()
+	defer dfg.mu.Unlock()
+
+	dfg.value.Store(value)
+	dfg.valueSum = sum
+	dfg.index++
+	if dfg.published != nil {
+		close(dfg.published)
+		dfg.published = nil
+	}
+}
+
+func (dfg *Dynafig[T]) restore(ctx context.Context) {
+
+	if dfg.SnapshotPath == "" {
+		return
+	}
+
+	snap, err := LoadSnapshot(dfg.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		dfg.Logger.Error(ctx, "failed to restore snapshot", err)
+		return
+	}
+
+	value, err := dfg.Decode(snap.Data)
+	if err != nil {
+		dfg.Logger.Error(ctx, "failed to restore snapshot", err)
+		return
+	}
+
+	dfg.Logger.Info(ctx, "restored snapshot", "path", dfg.SnapshotPath, "taken", snap.Time)
+
+	dfg.publish(&value, snap.Sum)
+
+	dfg.mu.Lock()
+	dfg.stale = true
+	dfg.sum = snap.Sum
+	dfg.mu.Unlock()
+
+	dfg.markReady()
+}
+
+func (dfg *Dynafig[T]) save(ctx context.Context, data []byte) {
+
+	if dfg.SnapshotPath == "" {
+		return
+	}
+
+	snap := Snapshot{
+		Data: data,
+		Sum:  dfg.sum,
+		Time: time.Now().UTC(),
+	}
+
+	err := SaveSnapshot(dfg.SnapshotPath, snap)
+	if err != nil {
+		dfg.Logger.Error(ctx, "failed to save snapshot", err)
+	}
+}
+
+func (dfg *Dynafig[T]) confirm() {
+
+	dfg.mu.Lock()
+	defer dfg.mu.Unlock()
+
+	dfg.stale = false
+	dfg.failures = 0
+	dfg.lastErr = nil
+}
+
+func (dfg *Dynafig[T]) fail(ctx context.Context, err error) {
+
+	dfg.mu.Lock()
+	dfg.failures++
+	dfg.lastErr = err
+	failures := dfg.failures
+	dfg.mu.Unlock()
+
+	delay := dfg.Backoff.Delay(failures)
+	dfg.Logger.Error(ctx, "failed to watch", err, "failures", failures, "backoff", delay)
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+}
+
+func (dfg *Dynafig[T]) notify(ctx context.Context, old, updated T) {
+
+	// callbacks are called without the lock, so they're free to subscribe and such
+	// registration only appends, so those in hand are unaffected
+
+	dfg.subMu.Lock()
+	callbacks := dfg.callbacks
+	dfg.subMu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(ctx, old, updated)
+	}
+
+	// sending is under the lock, as unsubscribe closes
+
+	dfg.subMu.Lock()
+	defer dfg.subMu.Unlock()
+
+	change := Change[T]{Old: old, New: updated}
+	for _, sub := range dfg.subs {
+		select {
+		case sub <- change:
+			continue
+		default:
+		}
+
+		// full, drop oldest to make room
+		// only the worker sends, so room is assured
+
+		select {
+		case <-sub:
+		default:
+		}
+		sub <- change
+	}
+}
+
+func (dfg *Dynafig[T]) unchanged(data []byte) bool {
+
+	dfg.hash.Write(data)
+	newSum := fmt.Sprintf("%x", dfg.hash.Sum(nil))
+	dfg.hash.Reset()
+
+	if dfg.sum == newSum {
+		return true
+	}
+
+	dfg.sum = newSum
+	return false
+}
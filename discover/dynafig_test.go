@@ -0,0 +1,365 @@
+package discover_test
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/discover"
+	"configstate/discover/mock"
+)
+
+var _ = Describe("Dynafig", func() {
+
+	Describe("getting dynamic config", func() {
+
+		type flags map[string]bool
+
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+			wg     sync.WaitGroup
+
+			mockedLogger *mock.LoggerMock
+			dfg          *Dynafig[flags]
+
+			data string
+			mu   sync.RWMutex
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+
+			mockedLogger = &mock.LoggerMock{
+				ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+				InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+				WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+					return ctx
+				},
+			}
+
+			dfg = &Dynafig[flags]{
+				Logger: mockedLogger,
+				Poller: &mock.PollerMock{
+					PollFunc: func(ctx context.Context) ([]byte, error) {
+						err := ctx.Err()
+						if err != nil {
+							return nil, err
+						}
+						time.Sleep(time.Microsecond)
+
+						mu.RLock()
+						defer mu.RUnlock()
+						return []byte(data), nil
+					},
+				},
+				Decode: func(data []byte) (value flags, err error) {
+					err = json.Unmarshal(data, &value)
+					return
+				},
+				Name: "flags",
+			}
+
+			data = `{"thumbnails":true}`
+		})
+
+		When("worker is running", func() {
+			BeforeEach(func() {
+				dfg.Start(ctx, &wg)
+			})
+
+			It("reports value, detects change, and stops when ctx is cancelled", func(ctx SpecContext) {
+
+				Eventually(dfg.Value).Should(Equal(flags{"thumbnails": true}))
+
+				mu.Lock()
+				data = `{"thumbnails":false,"watermark":true}`
+				mu.Unlock()
+
+				Eventually(dfg.Value).Should(Equal(flags{"thumbnails": false, "watermark": true}))
+
+				cancel()
+				wg.Wait()
+
+				infoCalls := mockedLogger.InfoCalls()
+				Expect(infoCalls).To(HaveLen(5))
+				Expect(infoCalls[1].Msg).To(Equal("updating flags"))
+				Expect(infoCalls[2].Msg).To(Equal("updating flags"))
+
+				Expect(mockedLogger.ErrorCalls()).To(HaveLen(0))
+
+			}, SpecTimeout(time.Second))
+		})
+
+		When("subscribed to changes", func() {
+			var (
+				changes     <-chan Change[flags]
+				unsubscribe func()
+				slow        <-chan Change[flags]
+				called      chan Change[flags]
+			)
+
+			BeforeEach(func() {
+				changes, unsubscribe = dfg.Subscribe(4)
+				slow, _ = dfg.Subscribe(0)
+
+				called = make(chan Change[flags], 4)
+				dfg.OnChange(func(ctx context.Context, old, updated flags) {
+					called <- Change[flags]{Old: old, New: updated}
+				})
+
+				dfg.Start(ctx, &wg)
+			})
+
+			It("delivers old and new values, dropping oldest for slow subscribers", func(ctx SpecContext) {
+
+				Eventually(changes).Should(Receive(Equal(Change[flags]{
+					Old: nil,
+					New: flags{"thumbnails": true},
+				})))
+				Eventually(called).Should(Receive(Equal(Change[flags]{
+					Old: nil,
+					New: flags{"thumbnails": true},
+				})))
+
+				mu.Lock()
+				data = `{"thumbnails":false}`
+				mu.Unlock()
+
+				expected := Change[flags]{
+					Old: flags{"thumbnails": true},
+					New: flags{"thumbnails": false},
+				}
+				Eventually(changes).Should(Receive(Equal(expected)))
+				Eventually(called).Should(Receive(Equal(expected)))
+				Eventually(slow).Should(Receive(Equal(expected)))
+
+				unsubscribe()
+				Expect(changes).To(BeClosed())
+
+				cancel()
+				wg.Wait()
+
+			}, SpecTimeout(time.Second))
+		})
+
+		When("a callback subscribes", func() {
+			var (
+				subscribed chan (<-chan Change[flags])
+			)
+
+			BeforeEach(func() {
+				subscribed = make(chan (<-chan Change[flags]), 1)
+				dfg.OnChange(func(ctx context.Context, old, updated flags) {
+					if old == nil {
+						changes, _ := dfg.Subscribe(1)
+						subscribed <- changes
+					}
+				})
+
+				dfg.Start(ctx, &wg)
+			})
+
+			It("does not deadlock and delivers the next change", func(ctx SpecContext) {
+
+				var changes <-chan Change[flags]
+				Eventually(subscribed).Should(Receive(&changes))
+
+				mu.Lock()
+				data = `{"thumbnails":false}`
+				mu.Unlock()
+
+				Eventually(changes).Should(Receive(Equal(Change[flags]{
+					Old: flags{"thumbnails": true},
+					New: flags{"thumbnails": false},
+				})))
+
+				cancel()
+				wg.Wait()
+
+			}, SpecTimeout(time.Second))
+		})
+
+		When("keeping a snapshot", func() {
+			var (
+				path string
+			)
+
+			BeforeEach(func() {
+				path = filepath.Join(GinkgoT().TempDir(), "flags.json")
+				dfg.SnapshotPath = path
+				dfg.Start(ctx, &wg)
+			})
+
+			It("saves each update and restores stale on a cold start", func(ctx SpecContext) {
+
+				Eventually(dfg.Value).Should(Equal(flags{"thumbnails": true}))
+				Expect(dfg.Stale()).To(BeFalse())
+
+				cancel()
+				wg.Wait()
+
+				snap, err := LoadSnapshot(path)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(snap.Data)).To(Equal(`{"thumbnails":true}`))
+
+				var (
+					backendUp bool
+					upMu      sync.RWMutex
+				)
+				cold := &Dynafig[flags]{
+					Logger: mockedLogger,
+					Poller: &mock.PollerMock{
+						PollFunc: func(ctx context.Context) ([]byte, error) {
+							err := ctx.Err()
+							if err != nil {
+								return nil, err
+							}
+							time.Sleep(time.Microsecond)
+
+							upMu.RLock()
+							defer upMu.RUnlock()
+							if !backendUp {
+								return nil, errors.Errorf("backend down")
+							}
+							return []byte(`{"thumbnails":true}`), nil
+						},
+					},
+					Decode:       dfg.Decode,
+					Name:         "flags",
+					SnapshotPath: path,
+				}
+
+				coldCtx, coldCancel := context.WithCancel(context.Background())
+				cold.Start(coldCtx, &wg)
+
+				Expect(cold.Value()).To(Equal(flags{"thumbnails": true}))
+				Expect(cold.Stale()).To(BeTrue())
+				Consistently(cold.Stale).Should(BeTrue())
+
+				upMu.Lock()
+				backendUp = true
+				upMu.Unlock()
+
+				Eventually(cold.Stale).Should(BeFalse())
+				Expect(cold.Value()).To(Equal(flags{"thumbnails": true}))
+
+				coldCancel()
+				wg.Wait()
+
+			}, SpecTimeout(2*time.Second))
+		})
+
+		When("poller syncs without data", func() {
+			var (
+				synced chan struct{}
+			)
+
+			BeforeEach(func() {
+				synced = make(chan struct{})
+				dfg.Poller = &syncPoller{
+					PollerMock: mock.PollerMock{
+						PollFunc: func(ctx context.Context) ([]byte, error) {
+							<-ctx.Done()
+							return nil, ctx.Err()
+						},
+					},
+					synced: synced,
+				}
+				dfg.Start(ctx, &wg)
+			})
+
+			It("becomes ready with the zero value", func(ctx SpecContext) {
+
+				Expect(dfg.Ready()).To(BeFalse())
+
+				close(synced)
+
+				Expect(dfg.WaitReady(ctx)).To(Succeed())
+				Expect(dfg.Value()).To(BeNil())
+
+				cancel()
+				wg.Wait()
+
+			}, SpecTimeout(time.Second))
+		})
+
+		When("polling fails a few times", func() {
+			var (
+				fails int
+			)
+
+			BeforeEach(func() {
+				fails = 3
+				dfg.Backoff = Backoff{Base: time.Millisecond, Max: 5 * time.Millisecond}
+				dfg.Poller = &mock.PollerMock{
+					PollFunc: func(ctx context.Context) ([]byte, error) {
+						err := ctx.Err()
+						if err != nil {
+							return nil, err
+						}
+
+						mu.Lock()
+						defer mu.Unlock()
+						if fails > 0 {
+							fails--
+							return nil, errors.Errorf("backend down")
+						}
+						return []byte(data), nil
+					},
+				}
+				dfg.Start(ctx, &wg)
+			})
+
+			It("backs off, counting failures until success", func(ctx SpecContext) {
+
+				Eventually(dfg.Value).Should(Equal(flags{"thumbnails": true}))
+				Expect(dfg.Status()).To(Equal(Status{Ready: true}))
+
+				errorCalls := mockedLogger.ErrorCalls()
+				Expect(errorCalls).To(HaveLen(3))
+				Expect(errorCalls[2].Kv).To(Equal([]any{"failures", 3, "backoff", 4 * time.Millisecond}))
+
+				cancel()
+				wg.Wait()
+
+			}, SpecTimeout(time.Second))
+		})
+
+		When("data does not decode", func() {
+			BeforeEach(func() {
+				data = `["thumbnails"]`
+				dfg.Start(ctx, &wg)
+			})
+
+			It("logs an error and keeps the zero value", func(ctx SpecContext) {
+
+				Eventually(mockedLogger.ErrorCalls).ShouldNot(BeEmpty())
+				Expect(dfg.Value()).To(BeNil())
+				Expect(dfg.Status().Failures).To(Equal(1))
+				Expect(dfg.Status().Error).To(ContainSubstring("cannot unmarshal"))
+
+				cancel()
+				wg.Wait()
+
+			}, SpecTimeout(time.Second))
+		})
+	})
+
+})
+
+type syncPoller struct {
+	mock.PollerMock
+	synced chan struct{}
+}
+
+func (sp *syncPoller) Synced() <-chan struct{} {
+	return sp.synced
+}
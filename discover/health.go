@@ -0,0 +1,287 @@
+package discover
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"configstate/entity"
+)
+
+const (
+	checkInterval time.Duration = 10 * time.Second
+)
+
+// Health is the state of a service as found by Checker.
+type Health struct {
+	Healthy   bool      `json:"healthy"`
+	Successes int       `json:"successes"`
+	Failures  int       `json:"failures"`
+	Checked   time.Time `json:"checked"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Checker periodically probes services, tracking whether each is up or down.
+//
+// Services are taken to be healthy until Fall consecutive probes fail,
+// and unhealthy from then until Rise consecutive probes succeed.
+type Checker struct {
+	Logger Logger
+	Client *http.Client
+	// Path is requested relative to each service's uri, with a 2xx response taken as healthy.
+	Path string
+	// Interval between checks, defaulting to ten seconds.
+	Interval time.Duration
+	// Timeout for each probe, defaulting to Interval.
+	Timeout  time.Duration
+	Rise     int
+	Fall     int
+	mu       sync.RWMutex
+	states   map[string]Health
+	changes  atomic.Int64
+	filtered atomic.Pointer[filtered]
+	lifeMu   sync.Mutex
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+}
+
+// Health returns the health of each service checked so far, by uri.
+func (chk *Checker) Health() (states map[string]Health) {
+
+	chk.mu.RLock()
+	defer chk.mu.RUnlock()
+
+	states = make(map[string]Health, len(chk.states))
+	for uri, state := range chk.states {
+		states[uri] = state
+	}
+
+	return
+}
+
+// Filter returns a view of services from source that are healthy.
+//
+// Views are cached, so a view is only rebuilt when source or health has changed.
+func (chk *Checker) Filter(source View) View {
+
+	changes := chk.changes.Load()
+
+	current := chk.filtered.Load()
+	if current != nil && current.source.same(source) && current.changes == changes {
+		return current.view
+	}
+
+	chk.mu.RLock()
+	services := entity.Services{}
+	for i := 0; i < source.Len(); i++ {
+		state, ok := chk.states[source.Uri(i)]
+		if !ok || state.Healthy {
+			services = append(services, source.services[i])
+		}
+	}
+	chk.mu.RUnlock()
+
+	updated := &filtered{
+		source:  source,
+		changes: changes,
+		view:    View{services: services},
+	}
+	chk.filtered.Store(updated)
+
+	return updated.view
+}
+
+// unexported
+
+type filtered struct {
+	source  View
+	changes int64
+	expires time.Time
+	view    View
+}
+
+func (chk *Checker) start(ctx context.Context, wg *sync.WaitGroup, source func() View) {
+
+	chk.lifeMu.Lock()
+	defer chk.lifeMu.Unlock()
+
+	if chk.Client == nil {
+		chk.Client = &http.Client{}
+	}
+	if chk.Interval <= 0 {
+		chk.Interval = checkInterval
+	}
+	if chk.Timeout <= 0 {
+		chk.Timeout = chk.Interval
+	}
+
+	ctx, chk.cancel = context.WithCancel(ctx)
+
+	wg.Add(1)
+	chk.workers.Add(1)
+	go chk.work(ctx, wg, source)
+}
+
+func (chk *Checker) stop(ctx context.Context) (err error) {
+
+	chk.lifeMu.Lock()
+	defer chk.lifeMu.Unlock()
+
+	if chk.cancel == nil {
+		return
+	}
+	chk.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		chk.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		chk.cancel = nil
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(), "health checker did not stop")
+	}
+
+	return
+}
+
+func (chk *Checker) work(ctx context.Context, wg *sync.WaitGroup, source func() View) {
+
+	defer wg.Done()
+	defer chk.workers.Done()
+
+	chk.Logger.Info(ctx, "health checker starting", "path", chk.Path, "interval", chk.Interval)
+
+	ticker := time.NewTicker(chk.Interval)
+	defer ticker.Stop()
+
+	for {
+		chk.check(ctx, source())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			chk.Logger.Info(ctx, "health checker stopped")
+			return
+		}
+	}
+}
+
+func (chk *Checker) check(ctx context.Context, vw View) {
+
+	uris := map[string]bool{}
+	for i := 0; i < vw.Len(); i++ {
+		uris[vw.Uri(i)] = true
+	}
+
+	var mu sync.Mutex
+	var probes sync.WaitGroup
+	results := make(map[string]error, len(uris))
+	for uri := range uris {
+		probes.Add(1)
+		go func(uri string) {
+			defer probes.Done()
+
+			err := chk.probe(ctx, uri)
+
+			mu.Lock()
+			results[uri] = err
+			mu.Unlock()
+		}(uri)
+	}
+	probes.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	chk.mu.Lock()
+	defer chk.mu.Unlock()
+
+	states := make(map[string]Health, len(results))
+	for uri, err := range results {
+		state, ok := chk.states[uri]
+		if !ok {
+			state = Health{Healthy: true}
+		}
+
+		before := state.Healthy
+		state = chk.record(ctx, uri, state, err)
+		if state.Healthy != before {
+			chk.changes.Add(1)
+		}
+		states[uri] = state
+	}
+
+	// services no longer published are dropped
+
+	chk.states = states
+}
+
+func (chk *Checker) record(ctx context.Context, uri string, state Health, err error) Health {
+
+	state.Checked = time.Now()
+	state.Error = ""
+
+	if err != nil {
+		state.Error = err.Error()
+		state.Successes = 0
+		state.Failures++
+		if state.Healthy && state.Failures >= chk.Fall {
+			state.Healthy = false
+			chk.Logger.Error(ctx, "service is down", err, "uri", uri, "failures", state.Failures)
+		}
+		return state
+	}
+
+	state.Failures = 0
+	state.Successes++
+	if !state.Healthy && state.Successes >= chk.Rise {
+		state.Healthy = true
+		chk.Logger.Info(ctx, "service is up", "uri", uri, "successes", state.Successes)
+	}
+
+	return state
+}
+
+func (chk *Checker) probe(ctx context.Context, uri string) (err error) {
+
+	base, err := url.Parse(uri)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse service uri: %s", uri)
+		return
+	}
+	target := base.JoinPath(chk.Path).String()
+
+	ctx, cancel := context.WithTimeout(ctx, chk.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create health request for: %s", target)
+		return
+	}
+
+	response, err := chk.Client.Do(request)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check health at: %s", target)
+		return
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		err = errors.Errorf("unexpected status code %d from: %s", response.StatusCode, target)
+	}
+
+	return
+}
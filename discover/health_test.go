@@ -0,0 +1,213 @@
+package discover_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/discover"
+	"configstate/discover/mock"
+	"configstate/entity"
+)
+
+var _ = Describe("Checker", func() {
+
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		wg      sync.WaitGroup
+		steady  *httptest.Server
+		flaky   *httptest.Server
+		down    atomic.Bool
+		paths   chan string
+		dsc     *Discover
+		healthy func() []string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		down.Store(false)
+		paths = make(chan string, 99)
+
+		steady = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			select {
+			case paths <- request.URL.Path:
+			default:
+			}
+		}))
+		flaky = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if down.Load() {
+				writer.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+
+		data, err := json.Marshal(entity.Services{
+			{Uri: steady.URL + "/api", Caps: []entity.Capability{{Name: "resize", Capacity: 1}}},
+			{Uri: flaky.URL + "/api", Caps: []entity.Capability{{Name: "resize", Capacity: 1}}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		dsc = &Discover{
+			Logger: &mock.LoggerMock{
+				ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+				InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+				WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+					return ctx
+				},
+			},
+			Poller:       once(data),
+			ReadyTimeout: time.Second,
+			Checker: &Checker{
+				Path:     "/health",
+				Interval: 10 * time.Millisecond,
+				Rise:     2,
+				Fall:     2,
+			},
+		}
+
+		healthy = func() (uris []string) {
+			vw := dsc.Healthy()
+			for i := 0; i < vw.Len(); i++ {
+				uris = append(uris, vw.Uri(i))
+			}
+			return
+		}
+	})
+
+	JustBeforeEach(func() {
+		err := dsc.Start(ctx, &wg)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+		steady.Close()
+		flaky.Close()
+	})
+
+	When("all services are up", func() {
+		It("probes the health path and includes them all", func() {
+			Eventually(paths).Should(Receive(Equal("/api/health")))
+
+			Eventually(dsc.Health).Should(HaveLen(2))
+			for _, state := range dsc.Health() {
+				Expect(state.Healthy).To(BeTrue())
+			}
+			Expect(healthy()).To(HaveLen(2))
+		})
+	})
+
+	When("checks come and go", func() {
+		It("keeps the etag steady until health changes", func() {
+			Eventually(dsc.Health).Should(HaveLen(2))
+
+			handlers := map[string]http.HandlerFunc{}
+			dsc.Register(&mock.RouterMock{
+				SetFunc: func(method, path string, handler http.HandlerFunc) {
+					handlers[path] = handler
+				},
+			})
+			get := func(etag string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest("GET", "/services", nil)
+				request.Header.Set("If-None-Match", etag)
+				handlers["/services"](recorder, request)
+				return recorder
+			}
+
+			etag := get("").Header().Get("etag")
+			checked := dsc.Health()[steady.URL+"/api"].Checked
+
+			Eventually(func() time.Time {
+				return dsc.Health()[steady.URL+"/api"].Checked
+			}).Should(BeTemporally(">", checked))
+
+			recorder := get(etag)
+			Expect(recorder.Code).To(Equal(304))
+			Expect(recorder.Header().Get("etag")).To(Equal(etag))
+
+			down.Store(true)
+			Eventually(healthy).Should(HaveLen(1))
+
+			recorder = get(etag)
+			Expect(recorder.Code).To(Equal(200))
+			Expect(recorder.Header().Get("etag")).ToNot(Equal(etag))
+		})
+	})
+
+	When("a service goes down", func() {
+		JustBeforeEach(func() {
+			Eventually(dsc.Health).Should(HaveLen(2))
+			down.Store(true)
+		})
+
+		It("is filtered out once past the fall threshold and back in past rise", func() {
+			Eventually(healthy).Should(Equal([]string{steady.URL + "/api"}))
+
+			state := dsc.Health()[flaky.URL+"/api"]
+			Expect(state.Healthy).To(BeFalse())
+			Expect(state.Failures).To(BeNumerically(">=", 2))
+			Expect(state.Error).To(ContainSubstring("503"))
+
+			sel := dsc.Selector("resize")
+			for i := 0; i < 3; i++ {
+				svc, err := sel.Select()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(svc.Uri).To(Equal(steady.URL + "/api"))
+			}
+
+			down.Store(false)
+			Eventually(healthy).Should(HaveLen(2))
+		})
+
+		It("annotates services with health", func() {
+			Eventually(healthy).Should(HaveLen(1))
+
+			handlers := map[string]http.HandlerFunc{}
+			dsc.Register(&mock.RouterMock{
+				SetFunc: func(method, path string, handler http.HandlerFunc) {
+					handlers[path] = handler
+				},
+			})
+
+			recorder := httptest.NewRecorder()
+			handlers["/services"](recorder, httptest.NewRequest("GET", "/services", nil))
+
+			body := struct {
+				Services entity.Services   `json:"services"`
+				Health   map[string]Health `json:"health"`
+			}{}
+			err := json.Unmarshal(recorder.Body.Bytes(), &body)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(body.Services).To(HaveLen(1))
+			Expect(body.Services[0].Uri).To(Equal(steady.URL + "/api"))
+			Expect(body.Health).To(HaveLen(2))
+			Expect(body.Health[flaky.URL+"/api"].Healthy).To(BeFalse())
+		})
+	})
+
+	When("stopped", func() {
+		It("stops checking", func() {
+			Eventually(paths).Should(Receive())
+
+			err := dsc.Stop(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			// let any handler serving a cancelled probe finish up
+			time.Sleep(20 * time.Millisecond)
+			for len(paths) > 0 {
+				<-paths
+			}
+			Consistently(paths, 50*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,92 @@
+package discover
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"configstate/entity"
+)
+
+// Selector picks services offering a capability, weighted by capacity.
+//
+// Picks are by smooth weighted round-robin, spreading them evenly in proportion to capacity.
+// Only healthy services are selected and weights are rebuilt when they change.
+type Selector struct {
+	Capability string
+	discover   *Discover
+	mu         sync.Mutex
+	view       View
+	weights    []weight
+}
+
+// Selector creates a Selector for the named capability.
+func (dsc *Discover) Selector(capability string) *Selector {
+
+	return &Selector{
+		Capability: capability,
+		discover:   dsc,
+	}
+}
+
+// Select returns a copy of the next service, erroring when none has capacity for the capability.
+//
+// Services with uris in skip are passed over, as when retrying elsewhere.
+func (sel *Selector) Select(skip ...string) (svc entity.Service, err error) {
+
+	vw := sel.discover.Healthy()
+
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+
+	if !sel.view.same(vw) || sel.weights == nil {
+		sel.rebuild(vw)
+	}
+
+	best := -1
+	total := 0
+	for i := range sel.weights {
+		if slices.Contains(skip, vw.Uri(sel.weights[i].index)) {
+			continue
+		}
+
+		sel.weights[i].current += sel.weights[i].capacity
+		total += sel.weights[i].capacity
+		if best == -1 || sel.weights[i].current > sel.weights[best].current {
+			best = i
+		}
+	}
+
+	if best == -1 {
+		err = errors.Errorf("no service with capacity for: %s", sel.Capability)
+		return
+	}
+	sel.weights[best].current -= total
+
+	svc = vw.Service(sel.weights[best].index)
+	return
+}
+
+// unexported
+
+type weight struct {
+	index    int
+	capacity int
+	current  int
+}
+
+func (sel *Selector) rebuild(vw View) {
+
+	sel.view = vw
+	sel.weights = []weight{}
+
+	for i := 0; i < vw.Len(); i++ {
+		capacity, ok := vw.Capacity(i, sel.Capability)
+		if !ok || capacity < 1 {
+			continue
+		}
+
+		sel.weights = append(sel.weights, weight{index: i, capacity: capacity})
+	}
+}
@@ -0,0 +1,128 @@
+package discover_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/discover"
+	"configstate/discover/mock"
+	"configstate/entity"
+)
+
+var _ = Describe("Selector", func() {
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+		dsc    *Discover
+		sel    *Selector
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		dsc = loaded(ctx, &wg, 3)
+		sel = dsc.Selector("resize")
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	picks := func(count int) (counts map[string]int) {
+
+		counts = map[string]int{}
+		for i := 0; i < count; i++ {
+			svc, err := sel.Select()
+			Expect(err).ToNot(HaveOccurred())
+			counts[svc.Uri]++
+		}
+
+		return
+	}
+
+	It("selects in proportion to capacity, skipping services without any", func() {
+		Expect(picks(30)).To(Equal(map[string]int{
+			"http://pool01.boxworld.org/api/v2": 10,
+			"http://pool02.boxworld.org/api/v2": 20,
+		}))
+	})
+
+	It("spreads picks smoothly", func() {
+		Expect(picks(3)).To(HaveLen(2))
+	})
+
+	When("new services are published", func() {
+		BeforeEach(func() {
+			_ = picks(3)
+
+			data, err := json.Marshal(entity.Services{
+				{Uri: "http://pool07.boxworld.org/api/v2", Caps: []entity.Capability{{Name: "resize", Capacity: 2}}},
+				{Uri: "http://pool08.boxworld.org/api/v2", Caps: []entity.Capability{{Name: "crop", Capacity: 9}}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = dsc.SetPoller(ctx, once(data))
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() int { return dsc.View().Len() }).Should(Equal(2))
+		})
+
+		It("rebuilds weights", func() {
+			Expect(picks(4)).To(Equal(map[string]int{
+				"http://pool07.boxworld.org/api/v2": 4,
+			}))
+		})
+	})
+
+	When("skipping a service", func() {
+		It("selects among the others", func() {
+			for i := 0; i < 3; i++ {
+				svc, err := sel.Select("http://pool02.boxworld.org/api/v2")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(svc.Uri).To(Equal("http://pool01.boxworld.org/api/v2"))
+			}
+		})
+
+		It("returns an error when all are skipped", func() {
+			_, err := sel.Select("http://pool01.boxworld.org/api/v2", "http://pool02.boxworld.org/api/v2")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("no service has the capability", func() {
+		BeforeEach(func() {
+			sel = dsc.Selector("crop")
+		})
+
+		It("returns an error", func() {
+			_, err := sel.Select()
+			Expect(err).To(MatchError(ContainSubstring("crop")))
+		})
+	})
+})
+
+// once is a poller handing over data once and then blocking, as a long poll would.
+func once(data []byte) *mock.PollerMock {
+
+	polled := false
+
+	return &mock.PollerMock{
+		PollFunc: func(ctx context.Context) ([]byte, error) {
+			if polled {
+				<-ctx.Done()
+			}
+			err := ctx.Err()
+			if err != nil {
+				return nil, err
+			}
+			polled = true
+			return data, nil
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package discover
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshot is a copy of successfully decoded data, for use on a cold start.
+type Snapshot struct {
+	Data []byte    `json:"data"`
+	Sum  string    `json:"sum"`
+	Time time.Time `json:"time"`
+}
+
+// SaveSnapshot writes a snapshot to path atomically, by way of a temp file and rename.
+func SaveSnapshot(path string, snap Snapshot) (err error) {
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		err = errors.Wrapf(err, "somehow failed to encode snapshot")
+		return
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create temp file for: %s", path)
+		return
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write temp file: %s", file.Name())
+		return
+	}
+
+	err = os.Rename(file.Name(), path)
+	err = errors.Wrapf(err, "failed to rename temp file to: %s", path)
+	return
+}
+
+// LoadSnapshot reads a snapshot from path.
+func LoadSnapshot(path string) (snap Snapshot, err error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read snapshot from: %s", path)
+		return
+	}
+
+	err = json.Unmarshal(data, &snap)
+	err = errors.Wrapf(err, "failed to decode snapshot from: %s", path)
+	return
+}
@@ -0,0 +1,133 @@
+package discover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/clarktrimble/delish/respond"
+	"github.com/pkg/errors"
+)
+
+const (
+	heartbeatInterval time.Duration = 15 * time.Second
+	streamBuffer      int           = 8
+)
+
+// unexported
+
+// getStream streams services as server-sent events, the full set on connect and then on each change.
+//
+// Given "diff=true", changes are sent as diffs, from the set last sent, rather than the full set.
+// A heartbeat comment is sent every Heartbeat, and the stream ends when the ctx passed to Start is done.
+func (dsc *Discover) getStream(writer http.ResponseWriter, request *http.Request) {
+
+	ctx := request.Context()
+	diffs := request.URL.Query().Get("diff") == "true"
+
+	changes, unsubscribe := dsc.Subscribe(streamBuffer)
+	defer unsubscribe()
+
+	// streams outlive the server's write timeout, so clear its deadline
+	// a buffered writer, as from response logging, supports neither this nor flushing
+	// see Unbuffered
+
+	rc := http.NewResponseController(writer)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to clear write deadline for event stream")
+		rp := &respond.Respond{Writer: writer, Logger: dsc.Logger}
+		rp.NotOk(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	writer.Header().Set("content-type", "text/event-stream")
+	writer.Header().Set("cache-control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+
+	// diffs are from the services last sent on this stream, rather than the change's old,
+	// as changes are dropped when a slow subscriber falls behind
+
+	sent := dsc.Services()
+	err = writeEvent(writer, rc, "services", sent)
+	if err != nil {
+		return
+	}
+
+	heartbeat := dsc.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = heartbeatInterval
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if diffs {
+				diff := sent.Diff(change.New)
+				if diff.Empty() {
+					continue
+				}
+				err = writeEvent(writer, rc, "diff", diff)
+			} else {
+				err = writeEvent(writer, rc, "services", change.New)
+			}
+			sent = change.New
+		case <-ticker.C:
+			_, err = io.WriteString(writer, ": heartbeat\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+		case <-dsc.done():
+			_ = writeEvent(writer, rc, "shutdown", map[string]string{"status": "shutting down"})
+			return
+		case <-ctx.Done():
+			// request ctx may well be the ctx passed to Start, as with delish's ReplaceCtx
+			select {
+			case <-dsc.done():
+				_ = writeEvent(writer, rc, "shutdown", map[string]string{"status": "shutting down"})
+			default:
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// done returns the done channel of the ctx passed to Start, nil when not yet started.
+func (dsc *Discover) done() <-chan struct{} {
+
+	dsc.mu.RLock()
+	defer dsc.mu.RUnlock()
+
+	if dsc.ctx == nil {
+		return nil
+	}
+
+	return dsc.ctx.Done()
+}
+
+func writeEvent(writer io.Writer, rc *http.ResponseController, event string, obj any) (err error) {
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		err = errors.Wrapf(err, "somehow failed to encode %s event", event)
+		return
+	}
+
+	_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return
+	}
+
+	err = rc.Flush()
+	return
+}
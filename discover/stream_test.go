@@ -0,0 +1,218 @@
+package discover_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clarktrimble/delish"
+	"github.com/clarktrimble/delish/mid"
+	"github.com/clarktrimble/hondo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"configstate/chi"
+	. "configstate/discover"
+	"configstate/discover/mock"
+	"configstate/entity"
+)
+
+var _ = Describe("Streaming services", func() {
+
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       sync.WaitGroup
+		dsc      *Discover
+		server   *httptest.Server
+		response *http.Response
+		reader   *bufio.Reader
+		target   string
+		lgr      *mock.LoggerMock
+		rtr      *chi.Chi
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+
+		dsc = loaded(ctx, &wg, 2)
+		dsc.Heartbeat = 20 * time.Millisecond
+
+		// served as in cmd, behind delish response logging
+
+		lgr = dsc.Logger.(*mock.LoggerMock)
+		rtr = chi.New()
+		dsc.Register(rtr)
+		svr := (&delish.Config{Timeout: time.Second}).NewWithLog(ctx, rtr, lgr)
+
+		server = httptest.NewServer(Unbuffered(svr.Handler, mid.ReplaceCtx(ctx, mid.LogRequest(lgr, hondo.Rand, rtr))))
+		target = server.URL + "/services/stream"
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		response, err = http.Get(target)
+		Expect(err).ToNot(HaveOccurred())
+		reader = bufio.NewReader(response.Body)
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+		response.Body.Close()
+		server.Close()
+	})
+
+	// next reads the next event, skipping heartbeats unless asked for
+
+	next := func(heartbeats bool) (event, data string) {
+
+		for {
+			line, err := reader.ReadString('\n')
+			Expect(err).ToNot(HaveOccurred())
+			line = strings.TrimSuffix(line, "\n")
+
+			switch {
+			case line == ": heartbeat" && heartbeats:
+				return "heartbeat", ""
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && event != "":
+				return
+			}
+		}
+	}
+
+	publish := func(count int) {
+
+		data, err := json.Marshal(services(count))
+		Expect(err).ToNot(HaveOccurred())
+
+		err = dsc.SetPoller(ctx, once(data))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("sends services on connect and on change", func() {
+		Expect(response.Header.Get("content-type")).To(Equal("text/event-stream"))
+
+		event, data := next(false)
+		Expect(event).To(Equal("services"))
+		Expect(data).To(MatchJSON(mustMarshal(services(2))))
+
+		publish(3)
+
+		event, data = next(false)
+		Expect(event).To(Equal("services"))
+		Expect(data).To(MatchJSON(mustMarshal(services(3))))
+	})
+
+	It("sends heartbeats", func() {
+		_, _ = next(false)
+
+		event, _ := next(true)
+		Expect(event).To(Equal("heartbeat"))
+	})
+
+	It("ends with shutdown when ctx is cancelled", func() {
+		_, _ = next(false)
+
+		cancel()
+
+		event, _ := next(false)
+		Expect(event).To(Equal("shutdown"))
+
+		_, err := reader.ReadString('\n')
+		Expect(err).To(HaveOccurred())
+	})
+
+	When("served buffered", func() {
+		BeforeEach(func() {
+			server.Close()
+			server = httptest.NewServer((&delish.Config{Timeout: time.Second}).NewWithLog(ctx, rtr, lgr).Handler)
+			target = server.URL + "/services/stream"
+		})
+
+		It("responds with and logs an error rather than a stream", func() {
+			Expect(response.StatusCode).To(Equal(500))
+			Expect(lgr.ErrorCalls()).ToNot(BeEmpty())
+			Expect(lgr.ErrorCalls()[0].Err).To(MatchError(ContainSubstring("failed to clear write deadline")))
+		})
+	})
+
+	When("asking for diffs", func() {
+		BeforeEach(func() {
+			target += "?diff=true"
+		})
+
+		It("sends diffs on change", func() {
+			event, _ := next(false)
+			Expect(event).To(Equal("services"))
+
+			publish(3)
+
+			event, data := next(false)
+			Expect(event).To(Equal("diff"))
+
+			diff := entity.Diff{}
+			err := json.Unmarshal([]byte(data), &diff)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(diff.Added).To(HaveLen(1))
+			Expect(diff.Added[0].Uri).To(Equal("http://pool02.boxworld.org/api/v2"))
+		})
+
+		It("keeps diffs whole when changes are dropped", func() {
+			_, data := next(false)
+			current := entity.Services{}
+			err := json.Unmarshal([]byte(data), &current)
+			Expect(err).ToNot(HaveOccurred())
+
+			// changes published in a rush overrun the subscription buffer
+
+			count := 2
+			err = dsc.SetPoller(ctx, &mock.PollerMock{
+				PollFunc: func(ctx context.Context) ([]byte, error) {
+					if count == 64 {
+						<-ctx.Done()
+						return nil, ctx.Err()
+					}
+					count++
+					return json.Marshal(services(count))
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			// applying diffs as they come gets to the last services published
+			// the last change is never dropped, so reading up to it
+
+			last := services(64)[63].Uri
+			for current[len(current)-1].Uri != last {
+				event, data := next(false)
+				Expect(event).To(Equal("diff"))
+
+				diff := entity.Diff{}
+				err := json.Unmarshal([]byte(data), &diff)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(diff.Removed).To(BeEmpty())
+				Expect(diff.Modified).To(BeEmpty())
+
+				current = append(current, diff.Added...)
+			}
+			Expect(current).To(Equal(services(64)))
+		})
+	})
+})
+
+func mustMarshal(obj any) string {
+
+	data, err := json.Marshal(obj)
+	Expect(err).ToNot(HaveOccurred())
+	return string(data)
+}
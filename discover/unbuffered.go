@@ -0,0 +1,39 @@
+package discover
+
+import (
+	"net/http"
+)
+
+// Unbuffered passes event streams and blocking queries straight to direct, and all other requests to buffered.
+//
+// Response logging middleware, such as delish's, buffers the response writer,
+// which then can neither flush events nor have its write deadline pushed out past a blocking query's wait.
+// With a server from delish's NewWithLog, for example:
+//
+//	server.Handler = discover.Unbuffered(server.Handler, mid.ReplaceCtx(ctx, mid.LogRequest(lgr, hondo.Rand, rtr)))
+func Unbuffered(buffered, direct http.Handler) http.HandlerFunc {
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+
+		if unbuffered(request) {
+			direct.ServeHTTP(writer, request)
+			return
+		}
+
+		buffered.ServeHTTP(writer, request)
+	}
+}
+
+// unexported
+
+func unbuffered(request *http.Request) bool {
+
+	switch request.URL.Path {
+	case "/services/stream":
+		return true
+	case "/services":
+		return request.URL.Query().Has("index")
+	}
+
+	return false
+}
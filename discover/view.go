@@ -0,0 +1,68 @@
+package discover
+
+import (
+	"configstate/entity"
+)
+
+// View is a read-only view of services as published by Discover.
+//
+// Views share their services with other readers, rather than copying,
+// and offer no way of modifying them.
+type View struct {
+	services entity.Services
+}
+
+// Len returns the number of services.
+func (vw View) Len() int {
+
+	return len(vw.services)
+}
+
+// Uri returns the uri of the i'th service.
+func (vw View) Uri(i int) string {
+
+	return vw.services[i].Uri
+}
+
+// Capacity returns the capacity of the i'th service for a named capability,
+// with ok false when the service does not have the capability.
+func (vw View) Capacity(i int, name string) (capacity int, ok bool) {
+
+	return vw.services[i].Capacity(name)
+}
+
+// Index returns the index of the service with uri, with ok false when not found.
+func (vw View) Index(uri string) (i int, ok bool) {
+
+	for i = range vw.services {
+		if vw.services[i].Uri == uri {
+			return i, true
+		}
+	}
+
+	return
+}
+
+// Service returns a copy of the i'th service.
+func (vw View) Service(i int) entity.Service {
+
+	return vw.services[i].Copy()
+}
+
+// Services returns a copy of all services.
+func (vw View) Services() entity.Services {
+
+	return vw.services.Copy()
+}
+
+// unexported
+
+// same is true when both views are of the same published services.
+func (vw View) same(other View) bool {
+
+	if len(vw.services) != len(other.services) {
+		return false
+	}
+
+	return len(vw.services) == 0 || &vw.services[0] == &other.services[0]
+}
@@ -0,0 +1,187 @@
+package discover_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/discover"
+	"configstate/discover/mock"
+	"configstate/entity"
+)
+
+var _ = Describe("View", func() {
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+		dsc    *Discover
+		vw     View
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		dsc = loaded(ctx, &wg, 2)
+		vw = dsc.View()
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	It("reads services without copying", func() {
+		Expect(vw.Len()).To(Equal(2))
+		Expect(vw.Uri(1)).To(Equal("http://pool01.boxworld.org/api/v2"))
+
+		capacity, ok := vw.Capacity(1, "resize")
+		Expect(ok).To(BeTrue())
+		Expect(capacity).To(Equal(1))
+
+		_, ok = vw.Capacity(1, "crop")
+		Expect(ok).To(BeFalse())
+
+		i, ok := vw.Index("http://pool01.boxworld.org/api/v2")
+		Expect(ok).To(BeTrue())
+		Expect(i).To(Equal(1))
+
+		_, ok = vw.Index("http://pool42.boxworld.org/api/v2")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("copies when asked for services", func() {
+		services := vw.Services()
+		services[0].Caps[0].Capacity = 99
+
+		svc := vw.Service(0)
+		svc.Caps[0].Capacity = 99
+
+		capacity, _ := vw.Capacity(0, "resize")
+		Expect(capacity).To(Equal(0))
+	})
+
+	When("worker has not started", func() {
+		It("is empty", func() {
+			Expect((&Discover{}).View().Len()).To(Equal(0))
+		})
+	})
+})
+
+// benchmarks compare reading services via View, via Services, and via the rwmutex and copy of old
+
+func BenchmarkView(b *testing.B) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() { cancel(); wg.Wait() }()
+
+	dsc := loaded(ctx, &wg, 24)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		total := 0
+		for pb.Next() {
+			vw := dsc.View()
+			for i := 0; i < vw.Len(); i++ {
+				capacity, _ := vw.Capacity(i, "resize")
+				total += capacity
+			}
+		}
+		_ = total
+	})
+}
+
+func BenchmarkServices(b *testing.B) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() { cancel(); wg.Wait() }()
+
+	dsc := loaded(ctx, &wg, 24)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		total := 0
+		for pb.Next() {
+			for _, svc := range dsc.Services() {
+				total += svc.Caps[0].Capacity
+			}
+		}
+		_ = total
+	})
+}
+
+func BenchmarkLockedCopy(b *testing.B) {
+
+	locked := &lockedServices{services: services(24)}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		total := 0
+		for pb.Next() {
+			for _, svc := range locked.Services() {
+				total += svc.Caps[0].Capacity
+			}
+		}
+		_ = total
+	})
+}
+
+type lockedServices struct {
+	mu       sync.RWMutex
+	services entity.Services
+}
+
+func (ls *lockedServices) Services() entity.Services {
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	return ls.services.Copy()
+}
+
+func services(count int) (services entity.Services) {
+
+	for i := 0; i < count; i++ {
+		services = append(services, entity.Service{
+			Uri:  fmt.Sprintf("http://pool%02d.boxworld.org/api/v2", i),
+			Caps: []entity.Capability{{Name: "resize", Capacity: i}},
+		})
+	}
+
+	return
+}
+
+func loaded(ctx context.Context, wg *sync.WaitGroup, count int) (dsc *Discover) {
+
+	data, err := json.Marshal(services(count))
+	if err != nil {
+		panic(err)
+	}
+
+	dsc = &Discover{
+		Logger: &mock.LoggerMock{
+			ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+			InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+			WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+				return ctx
+			},
+		},
+		Poller:       once(data),
+		ReadyTimeout: time.Second,
+	}
+
+	err = dsc.Start(ctx, wg)
+	if err != nil {
+		panic(err)
+	}
+
+	return
+}
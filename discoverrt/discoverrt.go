@@ -0,0 +1,158 @@
+// Package discoverrt implements the giant Tripper interface,
+// routing "discover://capability/path" requests to a service found by Discover.
+package discoverrt
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"configstate/discover"
+)
+
+const (
+	// Scheme is the url scheme routed by capability.
+	Scheme string = "discover"
+)
+
+// DiscoverRt implements the Tripper interface.
+//
+// Requests with a discover scheme have their url's host taken as a capability
+// and are rewritten against the uri of a service selected by capacity.
+// Other requests pass through untouched.
+type DiscoverRt struct {
+	// Retries is the number of further services tried on connection errors.
+	Retries   int
+	next      http.RoundTripper
+	discover  *discover.Discover
+	mu        sync.Mutex
+	selectors map[string]*discover.Selector
+}
+
+// New creates a DiscoverRt.
+func New(dsc *discover.Discover, retries int) *DiscoverRt {
+
+	return &DiscoverRt{
+		Retries:   retries,
+		next:      http.DefaultTransport,
+		discover:  dsc,
+		selectors: map[string]*discover.Selector{},
+	}
+}
+
+// Wrap sets the next round tripper, thereby wrapping it.
+func (rt *DiscoverRt) Wrap(next http.RoundTripper) {
+	rt.next = next
+}
+
+// RoundTrip routes requests to a service with the capability, retrying on connection errors.
+//
+// Outcomes are reported to Discover, so failing services can be ejected.
+//
+// Only requests whose body can be re-read are retried.
+func (rt *DiscoverRt) RoundTrip(request *http.Request) (response *http.Response, err error) {
+
+	if request.URL.Scheme != Scheme {
+		return rt.next.RoundTrip(request)
+	}
+
+	capability := request.URL.Host
+	sel := rt.selector(capability)
+	tried := []string{}
+	var lastErr error
+
+	for {
+		var routed *http.Request
+		var uri string
+		routed, uri, err = route(request, sel, tried)
+		if err != nil {
+			if lastErr != nil {
+				// out of services, report the last connection error
+				err = errors.Wrapf(lastErr, "failed to connect to any of: %v", tried)
+			}
+			return
+		}
+		tried = append(tried, uri)
+
+		response, err = rt.next.RoundTrip(routed)
+		rt.report(request, uri, response, err)
+		if err == nil || !dialFailed(err) || !replayable(request) || len(tried) > rt.Retries {
+			return
+		}
+		lastErr = err
+	}
+}
+
+// unexported
+
+func (rt *DiscoverRt) selector(capability string) (sel *discover.Selector) {
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	sel, ok := rt.selectors[capability]
+	if !ok {
+		sel = rt.discover.Selector(capability)
+		rt.selectors[capability] = sel
+	}
+
+	return
+}
+
+func (rt *DiscoverRt) report(request *http.Request, uri string, response *http.Response, err error) {
+
+	// server errors count against a service along with failure to get a response
+
+	if err == nil && response.StatusCode >= http.StatusInternalServerError {
+		err = errors.Errorf("unexpected status code %d", response.StatusCode)
+	}
+
+	rt.discover.Report(request.Context(), uri, err)
+}
+
+func route(request *http.Request, sel *discover.Selector, tried []string) (routed *http.Request, uri string, err error) {
+
+	svc, err := sel.Select(tried...)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to route: %s", request.URL)
+		return
+	}
+
+	uri = svc.Uri
+	base, err := url.Parse(uri)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse service uri: %s", svc.Uri)
+		return
+	}
+
+	target := base.JoinPath(request.URL.Path)
+	target.RawQuery = request.URL.RawQuery
+	target.Fragment = request.URL.Fragment
+
+	routed = request.Clone(request.Context())
+	routed.URL = target
+	routed.Host = ""
+
+	if request.GetBody != nil && len(tried) > 0 {
+		routed.Body, err = request.GetBody()
+		err = errors.Wrapf(err, "failed to get body for retry")
+	}
+
+	return
+}
+
+func dialFailed(err error) bool {
+
+	// dial errors are safe to retry, as nothing has been sent
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func replayable(request *http.Request) bool {
+
+	return request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+}
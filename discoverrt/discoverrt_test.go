@@ -0,0 +1,184 @@
+package discoverrt_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"configstate/discover"
+	"configstate/discover/mock"
+	. "configstate/discoverrt"
+	"configstate/entity"
+)
+
+func TestDiscoverRt(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DiscoverRt Suite")
+}
+
+var _ = Describe("DiscoverRt", func() {
+
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       sync.WaitGroup
+		cfg      *discover.Config
+		dsc      *discover.Discover
+		live     *httptest.Server
+		dead     string
+		services entity.Services
+		client   *http.Client
+		rt       *DiscoverRt
+		response *http.Response
+		err      error
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+
+		live = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, _ := io.ReadAll(request.Body)
+			fmt.Fprintf(writer, "%s %s?%s %s", request.Method, request.URL.Path, request.URL.RawQuery, body)
+		}))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		dead = fmt.Sprintf("http://%s/api", listener.Addr())
+		listener.Close()
+
+		cfg = &discover.Config{ReadyTimeout: time.Second}
+
+		services = entity.Services{
+			{Uri: dead, Caps: []entity.Capability{{Name: "resize", Capacity: 9}}},
+			{Uri: live.URL + "/api", Caps: []entity.Capability{{Name: "resize", Capacity: 1}}},
+		}
+	})
+
+	JustBeforeEach(func() {
+		dsc = started(ctx, &wg, cfg, services)
+		rt = New(dsc, 1)
+		client = &http.Client{Transport: rt}
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+		live.Close()
+	})
+
+	body := func() string {
+
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+
+		data, err := io.ReadAll(response.Body)
+		Expect(err).ToNot(HaveOccurred())
+		return string(data)
+	}
+
+	When("the selected service is down", func() {
+		It("retries on another and rewrites the url against its uri", func() {
+			response, err = client.Get("discover://resize/photos/123?size=small")
+			Expect(body()).To(Equal("GET /api/photos/123?size=small "))
+		})
+
+		It("retries with the body", func() {
+			response, err = client.Post("discover://resize/photos", "text/plain", strings.NewReader("bargle"))
+			Expect(body()).To(Equal("POST /api/photos? bargle"))
+		})
+
+		When("retries are off", func() {
+			JustBeforeEach(func() {
+				rt.Retries = 0
+			})
+
+			It("returns the connection error", func() {
+				_, err = client.Get("discover://resize/photos/123")
+				Expect(err).To(MatchError(ContainSubstring("connection refused")))
+			})
+		})
+	})
+
+	When("ejecting failing services", func() {
+		BeforeEach(func() {
+			cfg.EjectAfter = 1
+			cfg.EjectFor = time.Minute
+		})
+
+		It("reports the connection error, ejecting the service", func() {
+			response, err = client.Get("discover://resize/photos/123")
+			Expect(body()).To(Equal("GET /api/photos/123? "))
+
+			Expect(dsc.Healthy().Len()).To(Equal(1))
+			Expect(dsc.Breakers.States()[dead].Ejected).To(BeTrue())
+		})
+	})
+
+	When("all services are down", func() {
+		BeforeEach(func() {
+			services = services[:1]
+		})
+
+		It("returns the connection error", func() {
+			_, err = client.Get("discover://resize/photos/123")
+			Expect(err).To(MatchError(ContainSubstring("connection refused")))
+		})
+	})
+
+	When("no service has the capability", func() {
+		It("returns an error", func() {
+			_, err = client.Get("discover://crop/photos/123")
+			Expect(err).To(MatchError(ContainSubstring("no service with capacity for: crop")))
+		})
+	})
+
+	When("the scheme is not discover", func() {
+		It("passes the request through", func() {
+			response, err = client.Get(live.URL + "/direct")
+			Expect(body()).To(Equal("GET /direct? "))
+		})
+	})
+})
+
+func started(ctx context.Context, wg *sync.WaitGroup, cfg *discover.Config, services entity.Services) (dsc *discover.Discover) {
+
+	data, err := json.Marshal(services)
+	Expect(err).ToNot(HaveOccurred())
+
+	polled := false
+
+	dsc = cfg.New(
+		&mock.LoggerMock{
+			ErrorFunc: func(ctx context.Context, msg string, err error, kv ...any) {},
+			InfoFunc:  func(ctx context.Context, msg string, kv ...any) {},
+			WithFieldsFunc: func(ctx context.Context, kv ...interface{}) context.Context {
+				return ctx
+			},
+		},
+		&mock.PollerMock{
+			PollFunc: func(ctx context.Context) ([]byte, error) {
+				if polled {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				polled = true
+				return data, nil
+			},
+		},
+	)
+
+	err = dsc.Start(ctx, wg)
+	Expect(err).ToNot(HaveOccurred())
+	return
+}
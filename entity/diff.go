@@ -0,0 +1,101 @@
+package entity
+
+// CapacityDelta is a change in capacity of a named capability.
+//
+// A capability appearing has Old of zero and one disappearing has New of zero.
+type CapacityDelta struct {
+	Name string `json:"name"`
+	Old  int    `json:"old"`
+	New  int    `json:"new"`
+}
+
+// Modified is a service found before and after, but with changed capabilities.
+type Modified struct {
+	Uri    string          `json:"uri"`
+	Deltas []CapacityDelta `json:"deltas"`
+}
+
+// Diff is the difference between two multiplicities of Service, compared by Uri.
+type Diff struct {
+	Added    Services   `json:"added"`
+	Removed  Services   `json:"removed"`
+	Modified []Modified `json:"modified"`
+}
+
+// Diff compares services with updated services.
+func (services Services) Diff(updated Services) (diff Diff) {
+
+	diff = Diff{
+		Added:    Services{},
+		Removed:  Services{},
+		Modified: []Modified{},
+	}
+
+	before := services.byUri()
+	after := updated.byUri()
+
+	for _, svc := range updated {
+		old, ok := before[svc.Uri]
+		if !ok {
+			diff.Added = append(diff.Added, svc)
+			continue
+		}
+
+		deltas := capDeltas(old.Caps, svc.Caps)
+		if len(deltas) > 0 {
+			diff.Modified = append(diff.Modified, Modified{Uri: svc.Uri, Deltas: deltas})
+		}
+	}
+
+	for _, svc := range services {
+		if _, ok := after[svc.Uri]; !ok {
+			diff.Removed = append(diff.Removed, svc)
+		}
+	}
+
+	return
+}
+
+// Empty is true when nothing has changed.
+func (diff Diff) Empty() bool {
+
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Modified) == 0
+}
+
+// unexported
+
+func (services Services) byUri() map[string]Service {
+
+	byUri := map[string]Service{}
+	for _, svc := range services {
+		byUri[svc.Uri] = svc
+	}
+
+	return byUri
+}
+
+func capDeltas(before, after []Capability) (deltas []CapacityDelta) {
+
+	capacity := map[string]int{}
+	for _, cpb := range before {
+		capacity[cpb.Name] = cpb.Capacity
+	}
+
+	seen := map[string]bool{}
+	for _, cpb := range after {
+		seen[cpb.Name] = true
+
+		old, ok := capacity[cpb.Name]
+		if !ok || old != cpb.Capacity {
+			deltas = append(deltas, CapacityDelta{Name: cpb.Name, Old: old, New: cpb.Capacity})
+		}
+	}
+
+	for _, cpb := range before {
+		if !seen[cpb.Name] {
+			deltas = append(deltas, CapacityDelta{Name: cpb.Name, Old: cpb.Capacity})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,77 @@
+package entity_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/entity"
+)
+
+func TestEntity(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Entity Suite")
+}
+
+var _ = Describe("Diff", func() {
+
+	var (
+		before Services
+		after  Services
+		diff   Diff
+	)
+
+	BeforeEach(func() {
+		before = Services{
+			{Uri: "http://pool04.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 23}}},
+			{Uri: "http://pool05.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 23}}},
+			{Uri: "http://pool24.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 5}}},
+		}
+	})
+
+	JustBeforeEach(func() {
+		diff = before.Diff(after)
+	})
+
+	When("services are added, removed and modified", func() {
+		BeforeEach(func() {
+			after = Services{
+				{Uri: "http://pool04.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 23}}},
+				{Uri: "http://pool24.boxworld.org/api/v2", Caps: []Capability{{Name: "crop", Capacity: 3}}},
+				{Uri: "http://pool42.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 42}}},
+			}
+		})
+
+		It("reports each by uri with capacity deltas", func() {
+			Expect(diff.Empty()).To(BeFalse())
+			Expect(diff).To(Equal(Diff{
+				Added: Services{
+					{Uri: "http://pool42.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 42}}},
+				},
+				Removed: Services{
+					{Uri: "http://pool05.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 23}}},
+				},
+				Modified: []Modified{
+					{
+						Uri: "http://pool24.boxworld.org/api/v2",
+						Deltas: []CapacityDelta{
+							{Name: "crop", Old: 0, New: 3},
+							{Name: "resize", Old: 5, New: 0},
+						},
+					},
+				},
+			}))
+		})
+	})
+
+	When("nothing has changed", func() {
+		BeforeEach(func() {
+			after = before.Copy()
+		})
+
+		It("is empty", func() {
+			Expect(diff.Empty()).To(BeTrue())
+		})
+	})
+})
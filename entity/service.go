@@ -2,6 +2,7 @@ package entity
 
 import (
 	"encoding/json"
+	"net/url"
 
 	"github.com/pkg/errors"
 )
@@ -22,21 +23,85 @@ type Service struct {
 type Services []Service
 
 // DecodeServices unmarshals services.
-func DecodeServices(data []byte) (services []Service, err error) {
+func DecodeServices(data []byte) (services Services, err error) {
 
-	services = []Service{}
+	services = Services{}
 	err = json.Unmarshal(data, &services)
 	err = errors.Wrapf(err, "failed to unmarshal services from: %s", data)
 	return
 }
 
+// Copy makes a deep copy of a service.
+func (svc Service) Copy() (copied Service) {
+
+	copied = svc
+	if svc.Caps != nil {
+		copied.Caps = make([]Capability, len(svc.Caps))
+		copy(copied.Caps, svc.Caps)
+	}
+
+	return
+}
+
 // Copy makes a deep copy of services.
 func (services Services) Copy() (copied Services) {
 
-	// Todo: deep copy!
-
 	copied = make([]Service, len(services))
-	copy(copied, services)
+	for i, svc := range services {
+		copied[i] = svc.Copy()
+	}
+
+	return
+}
+
+// Capacity returns the capacity of a named capability, with ok false when the service does not have it.
+func (svc Service) Capacity(name string) (capacity int, ok bool) {
+
+	for _, cpb := range svc.Caps {
+		if cpb.Name == name {
+			return cpb.Capacity, true
+		}
+	}
 
 	return
 }
+
+// Filter returns services having a named capability with at least minCapacity.
+//
+// When name is empty, all services are returned.
+func (services Services) Filter(name string, minCapacity int) (filtered Services) {
+
+	filtered = Services{}
+	for _, svc := range services {
+		if name != "" {
+			capacity, ok := svc.Capacity(name)
+			if !ok || capacity < minCapacity {
+				continue
+			}
+		}
+		filtered = append(filtered, svc)
+	}
+
+	return
+}
+
+// Find returns the service with a uri or, failing that, the first with a host matching key.
+//
+// Host includes the port when present in the uri, "pool04.boxworld.org:8080" for example.
+func (services Services) Find(key string) (svc Service, ok bool) {
+
+	for _, svc = range services {
+		if svc.Uri == key {
+			return svc, true
+		}
+	}
+
+	for _, svc = range services {
+		parsed, err := url.Parse(svc.Uri)
+		if err == nil && parsed.Host == key {
+			return svc, true
+		}
+	}
+
+	return Service{}, false
+}
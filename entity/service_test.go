@@ -0,0 +1,149 @@
+package entity_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "configstate/entity"
+)
+
+var _ = Describe("Services", func() {
+
+	var (
+		services Services
+	)
+
+	BeforeEach(func() {
+		services = Services{
+			{Uri: "http://pool04.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 23}}},
+			{Uri: "http://pool24.boxworld.org/api/v2"},
+		}
+	})
+
+	Describe("copying", func() {
+		var (
+			copied Services
+		)
+
+		JustBeforeEach(func() {
+			copied = services.Copy()
+		})
+
+		It("is equal to the original", func() {
+			Expect(copied).To(Equal(services))
+		})
+
+		It("does not share capabilities with the original", func() {
+			copied[0].Caps[0].Capacity = 55
+			copied[0].Caps = append(copied[0].Caps, Capability{Name: "crop", Capacity: 3})
+			copied[1].Uri = "http://pool42.boxworld.org/api/v2"
+
+			Expect(services).To(Equal(Services{
+				{Uri: "http://pool04.boxworld.org/api/v2", Caps: []Capability{{Name: "resize", Capacity: 23}}},
+				{Uri: "http://pool24.boxworld.org/api/v2"},
+			}))
+		})
+	})
+
+	Describe("filtering", func() {
+		var (
+			name        string
+			minCapacity int
+			filtered    Services
+		)
+
+		BeforeEach(func() {
+			services = append(services, Service{
+				Uri:  "http://pool44.boxworld.org/api/v2",
+				Caps: []Capability{{Name: "crop", Capacity: 3}, {Name: "resize", Capacity: 5}},
+			})
+			name = "resize"
+			minCapacity = 0
+		})
+
+		JustBeforeEach(func() {
+			filtered = services.Filter(name, minCapacity)
+		})
+
+		It("includes services with the capability", func() {
+			Expect(filtered).To(HaveLen(2))
+			Expect(filtered[0].Uri).To(Equal("http://pool04.boxworld.org/api/v2"))
+			Expect(filtered[1].Uri).To(Equal("http://pool44.boxworld.org/api/v2"))
+		})
+
+		When("minimum capacity is given", func() {
+			BeforeEach(func() {
+				minCapacity = 10
+			})
+
+			It("includes services with enough capacity", func() {
+				Expect(filtered).To(HaveLen(1))
+				Expect(filtered[0].Uri).To(Equal("http://pool04.boxworld.org/api/v2"))
+			})
+		})
+
+		When("no service has the capability", func() {
+			BeforeEach(func() {
+				name = "rotate"
+			})
+
+			It("is empty", func() {
+				Expect(filtered).To(Equal(Services{}))
+			})
+		})
+
+		When("capability is empty", func() {
+			BeforeEach(func() {
+				name = ""
+			})
+
+			It("includes all services", func() {
+				Expect(filtered).To(HaveLen(3))
+			})
+		})
+	})
+
+	Describe("finding", func() {
+		var (
+			key string
+			svc Service
+			ok  bool
+		)
+
+		JustBeforeEach(func() {
+			svc, ok = services.Find(key)
+		})
+
+		When("key is a uri", func() {
+			BeforeEach(func() {
+				key = "http://pool24.boxworld.org/api/v2"
+			})
+
+			It("finds the service", func() {
+				Expect(ok).To(BeTrue())
+				Expect(svc.Uri).To(Equal(key))
+			})
+		})
+
+		When("key is a host", func() {
+			BeforeEach(func() {
+				key = "pool04.boxworld.org"
+			})
+
+			It("finds the service", func() {
+				Expect(ok).To(BeTrue())
+				Expect(svc.Uri).To(Equal("http://pool04.boxworld.org/api/v2"))
+			})
+		})
+
+		When("key is unknown", func() {
+			BeforeEach(func() {
+				key = "pool42.boxworld.org"
+			})
+
+			It("does not find a service", func() {
+				Expect(ok).To(BeFalse())
+			})
+		})
+	})
+})
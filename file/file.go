@@ -0,0 +1,196 @@
+// Package file is a file watcher.
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	limitInterval time.Duration = 3 * time.Second
+	limitBurst    int           = 3
+	pollInterval  time.Duration = 10 * time.Second
+)
+
+// Config is File configuration.
+type Config struct {
+	Path         string        `json:"path" desc:"file to be watched" required:"true"`
+	PollInterval time.Duration `json:"poll_interval" desc:"interval between checks for change, backing up notifications" default:"10s"`
+	NoNotify     bool          `json:"no_notify" desc:"check for change only every poll interval, as for network filesystems"`
+}
+
+// File watches a file for change.
+//
+// The file's directory is watched for notifications, with the file checked
+// for a change of its content's path, modification time or size on each.
+// Watching the directory rather than the file follows atomic symlink swaps,
+// such as kubelet makes when updating a mounted ConfigMap, and editors replacing files.
+//
+// The file is also checked every PollInterval, in case of missed notifications,
+// or when notifications are unavailable.
+type File struct {
+	Limiter      *rate.Limiter
+	LimitDelay   time.Duration
+	Path         string
+	PollInterval time.Duration
+	NoNotify     bool
+	watcher      *fsnotify.Watcher
+	ticker       *time.Ticker
+	last         signature
+	watching     bool
+}
+
+// New creates a File from Config.
+func (cfg *Config) New() *File {
+
+	return &File{
+		Limiter:      rate.NewLimiter(rate.Every(limitInterval), limitBurst),
+		Path:         cfg.Path,
+		PollInterval: cfg.PollInterval,
+		NoNotify:     cfg.NoNotify,
+	}
+}
+
+// Poll returns the file's content, right away on the first poll and on change thereafter.
+//
+// It will not return more frequently than once every few seconds, with a small burst allowed.
+func (fl *File) Poll(ctx context.Context) (data []byte, err error) {
+
+	delay := fl.Limiter.Reserve().Delay()
+	fl.LimitDelay += delay
+	time.Sleep(delay)
+
+	if !fl.watching {
+		fl.watch()
+		return fl.read()
+	}
+
+	var events chan fsnotify.Event
+	var errs chan error
+	if fl.watcher != nil {
+		events = fl.watcher.Events
+		errs = fl.watcher.Errors
+	}
+
+	for {
+		select {
+		case <-events:
+		case err = <-errs:
+			err = errors.Wrapf(err, "failed to watch: %s", fl.Path)
+			return
+		case <-fl.ticker.C:
+		case <-ctx.Done():
+			fl.stop()
+			// convert to Canceled as that's how Poller rolls
+			return nil, context.Canceled
+		}
+
+		var sig signature
+		sig, err = fl.signature()
+		if err != nil {
+			return
+		}
+		if sig != fl.last {
+			return fl.read()
+		}
+	}
+}
+
+// unexported
+
+type signature struct {
+	target  string
+	modTime time.Time
+	size    int64
+}
+
+func (fl *File) watch() {
+
+	interval := fl.PollInterval
+	if interval <= 0 {
+		interval = pollInterval
+	}
+
+	fl.watching = true
+	fl.ticker = time.NewTicker(interval)
+
+	if fl.NoNotify {
+		return
+	}
+
+	// fall back to ticker alone when notifications are unavailable
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+
+	err = watcher.Add(filepath.Dir(fl.Path))
+	if err != nil {
+		watcher.Close()
+		return
+	}
+
+	fl.watcher = watcher
+}
+
+func (fl *File) stop() {
+
+	if fl.watcher != nil {
+		fl.watcher.Close()
+	}
+	if fl.ticker != nil {
+		fl.ticker.Stop()
+	}
+
+	fl.watcher = nil
+	fl.ticker = nil
+	fl.watching = false
+}
+
+func (fl *File) signature() (sig signature, err error) {
+
+	target, err := filepath.EvalSymlinks(fl.Path)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to resolve: %s", fl.Path)
+		return
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to stat: %s", target)
+		return
+	}
+
+	sig = signature{
+		target:  target,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+	return
+}
+
+func (fl *File) read() (data []byte, err error) {
+
+	// take signature first, so a change while reading is caught next time around
+
+	sig, err := fl.signature()
+	if err != nil {
+		return
+	}
+
+	data, err = os.ReadFile(sig.target)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read: %s", sig.target)
+		return
+	}
+
+	fl.last = sig
+	return
+}
@@ -0,0 +1,175 @@
+package file_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+
+	. "configstate/file"
+)
+
+func TestFile(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "File Suite")
+}
+
+var _ = Describe("File", func() {
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		dir    string
+		path   string
+		cfg    *Config
+		fl     *File
+		data   []byte
+		err    error
+	)
+
+	write := func(name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		dir = GinkgoT().TempDir()
+		path = filepath.Join(dir, "services.json")
+
+		cfg = &Config{
+			Path:         path,
+			PollInterval: time.Minute,
+		}
+	})
+
+	JustBeforeEach(func() {
+		fl = cfg.New()
+		fl.Limiter = rate.NewLimiter(rate.Inf, 0)
+
+		data, err = fl.Poll(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// shortly polls with a short deadline, for checking that nothing turns up
+
+	shortly := func() (data []byte, err error) {
+
+		shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer shortCancel()
+
+		return fl.Poll(shortCtx)
+	}
+
+	When("the file is written in place", func() {
+		BeforeEach(func() {
+			write("services.json", "one")
+		})
+
+		It("responds with content and then with changed content", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("one"))
+
+			write("services.json", "two")
+
+			data, err = fl.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("two"))
+		})
+
+		It("ignores changes to other files in the directory", func() {
+			write("bargle.json", "other")
+
+			_, err = shortly()
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+
+	When("the file is swapped by symlink, kubelet style", func() {
+		swap := func(version, content string) {
+
+			// kubelet writes a timestamped dir, points ..data_tmp at it and renames over ..data
+
+			err := os.Mkdir(filepath.Join(dir, version), 0755)
+			Expect(err).ToNot(HaveOccurred())
+			write(filepath.Join(version, "services.json"), content)
+
+			err = os.Symlink(version, filepath.Join(dir, "..data_tmp"))
+			Expect(err).ToNot(HaveOccurred())
+			err = os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			swap("..2024_01_01", "one")
+			err := os.Symlink(filepath.Join("..data", "services.json"), path)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("follows the swap", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("one"))
+
+			swap("..2024_01_02", "two")
+
+			data, err = fl.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("two"))
+		})
+	})
+
+	When("notifications are off", func() {
+		BeforeEach(func() {
+			write("services.json", "one")
+			cfg.NoNotify = true
+			cfg.PollInterval = 20 * time.Millisecond
+		})
+
+		It("checks for change every poll interval", func() {
+			Expect(string(data)).To(Equal("one"))
+
+			write("services.json", "two!")
+
+			data, err = fl.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("two!"))
+		})
+	})
+
+	When("the file does not exist", func() {
+		It("returns an error and then content once it does", func() {
+			Expect(err).To(HaveOccurred())
+
+			write("services.json", "one")
+
+			data, err = fl.Poll(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("one"))
+		})
+	})
+
+	When("ctx is cancelled", func() {
+		BeforeEach(func() {
+			write("services.json", "one")
+		})
+
+		It("returns canceled and starts afresh on the next poll", func() {
+			cancel()
+
+			_, err = fl.Poll(ctx)
+			Expect(err).To(MatchError(context.Canceled))
+
+			data, err = fl.Poll(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("one"))
+		})
+	})
+})
@@ -1,9 +1,11 @@
+// Package nats is a NATS Key-Value watcher.
 package nats
 
 import (
 	"context"
 	"time"
 
+	"github.com/clarktrimble/launch"
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	"golang.org/x/time/rate"
@@ -14,37 +16,108 @@ const (
 	limitBurst    int           = 3
 )
 
+// KeyValue specifies a kv store that can be watched, as satisfied by nats.KeyValue.
+type KeyValue interface {
+	Watch(keys string, opts ...nats.WatchOpt) (nats.KeyWatcher, error)
+}
+
 // Config is Nats configuration.
 type Config struct {
-	Url    string `json:"url" desc:"nats server url" required:"true"`
-	Bucket string `json:"bucket" desc:"bucket be watched" required:"true"`
-	Key    string `json:"key" desc:"key to be watched" required:"true"`
+	Url       string        `json:"url" desc:"nats server url" required:"true"`
+	Bucket    string        `json:"bucket" desc:"bucket be watched" required:"true"`
+	Key       string        `json:"key" desc:"key to be watched" required:"true"`
+	CredsFile string        `json:"creds_file,omitempty" desc:"path to user credentials file"`
+	NkeyFile  string        `json:"nkey_file,omitempty" desc:"path to nkey seed file"`
+	User      string        `json:"user,omitempty" desc:"username for user/password auth"`
+	Pass      launch.Redact `json:"pass,omitempty" desc:"password for user/password auth"`
+	Token     launch.Redact `json:"token,omitempty" desc:"token for token auth"`
+	CaFile    string        `json:"ca_file,omitempty" desc:"path to ca cert for verifying server"`
+	CertFile  string        `json:"cert_file,omitempty" desc:"path to client cert for tls auth"`
+	KeyFile   string        `json:"key_file,omitempty" desc:"path to client key for tls auth"`
 }
 
 // Nats is a watch oriented representation of a nats server.
+//
+// Nats re-watches as needed, re-connecting when it owns the connection.
 type Nats struct {
 	Limiter    *rate.Limiter
 	LimitDelay time.Duration
+	Key        string
+	dial       func() (kv KeyValue, err error)
+	watcher    nats.KeyWatcher
 	updates    <-chan nats.KeyValueEntry
+	synced     chan struct{}
 }
 
-// New creates a Nats from Config.
+// New creates a Nats from Config, connecting and watching straight away.
+//
+// The connection is owned by Nats and re-established should it close.
 func (cfg *Config) New() (nt *Nats, err error) {
 
-	updates, err := updateChannel(cfg.Url, cfg.Bucket, cfg.Key)
+	opts, err := cfg.options()
 	if err != nil {
 		return
 	}
 
-	nt = &Nats{
-		Limiter: rate.NewLimiter(rate.Every(limitInterval), limitBurst),
-		updates: updates,
+	var nc *nats.Conn
+
+	dial := func() (kv KeyValue, err error) {
+
+		if nc == nil || nc.IsClosed() {
+			nc, err = nats.Connect(cfg.Url, opts...)
+			if err != nil {
+				err = errors.Wrap(err, "failed to connect to nats")
+				return
+			}
+		}
+
+		return keyValue(nc, cfg.Bucket)
 	}
 
-	return
+	return newNats(dial, cfg.Key)
+}
+
+// NewWithConn creates a Nats from Config using an existing connection, ignoring Url, auth and tls.
+//
+// The connection is owned by the caller and not re-established should it close.
+func (cfg *Config) NewWithConn(nc *nats.Conn) (nt *Nats, err error) {
+
+	dial := func() (kv KeyValue, err error) {
+
+		if nc.IsClosed() {
+			err = errors.Errorf("nats connection is closed")
+			return
+		}
+
+		return keyValue(nc, cfg.Bucket)
+	}
+
+	return newNats(dial, cfg.Key)
+}
+
+// NewWithKv creates a Nats watching key in an existing kv store.
+func NewWithKv(kv KeyValue, key string) (nt *Nats, err error) {
+
+	dial := func() (KeyValue, error) {
+		return kv, nil
+	}
+
+	return newNats(dial, key)
+}
+
+// Synced returns a channel that is closed once the watcher has replayed initial values.
+//
+// When the key does not exist, Synced closes without a value having been polled.
+func (nt *Nats) Synced() <-chan struct{} {
+
+	return nt.synced
 }
 
 // Poll sings and dances its way to satisfying the Poller interface.
+//
+// When the watcher's channel closes, the watcher is stopped and an error returned.
+// The next Poll re-watches, re-connecting if need be, with any delay in between
+// left to the caller's backoff, such as Dynafig's.
 func (nt *Nats) Poll(ctx context.Context) ([]byte, error) {
 
 	// rate limit just in case
@@ -54,34 +127,93 @@ func (nt *Nats) Poll(ctx context.Context) ([]byte, error) {
 	nt.LimitDelay += delay
 	time.Sleep(delay)
 
+	if nt.updates == nil {
+		err := nt.watch()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// return data found or signal shutdown
 
-	select {
-	case kve := <-nt.updates:
-		if kve == nil {
-			// seeing nil after first value from channel then it settles down
-			return nil, errors.Errorf("got nil from kv watcher channel")
+	for {
+		select {
+		case kve, ok := <-nt.updates:
+			if !ok {
+				nt.stop()
+				return nil, errors.Errorf("kv watcher channel closed")
+			}
+			if kve == nil {
+				// watcher sends nil once initial values are replayed, keep waiting
+				nt.markSynced()
+				continue
+			}
+			return kve.Value(), nil
+		case <-ctx.Done():
+			nt.stop()
+			// convert to Canceled as that's how Poller rolls
+			return nil, context.Canceled
 		}
-		return kve.Value(), nil
-	case <-ctx.Done():
-		// convert to Canceled as that's how Poller rolls
-		return nil, context.Canceled
 	}
 }
 
-// updateChannel connects, ... , and eventually finds us an update channel.
-//
-// Of course, this code is not all that testable wo a nats server running
-// b-but can unit Poll by sneaking in our own "updates" channel. Todo!
-//
-// In real life, some of these steps may have already been taken, with battle-hardened opts, etc.
-func updateChannel(url, bucket, key string) (updates <-chan nats.KeyValueEntry, err error) {
+// unexported
 
-	nc, err := nats.Connect(url)
-	if err != nil {
-		err = errors.Wrap(err, "failed to connect to nats")
+func newNats(dial func() (KeyValue, error), key string) (nt *Nats, err error) {
+
+	nt = &Nats{
+		Limiter: rate.NewLimiter(rate.Every(limitInterval), limitBurst),
+		Key:     key,
+		dial:    dial,
+		synced:  make(chan struct{}),
+	}
+
+	err = nt.watch()
+	return
+}
+
+func (cfg *Config) options() (opts []nats.Option, err error) {
+
+	opts = []nats.Option{}
+
+	if cfg.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	}
+
+	if cfg.NkeyFile != "" {
+		var opt nats.Option
+		opt, err = nats.NkeyOptionFromSeed(cfg.NkeyFile)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to load nkey seed from: %s", cfg.NkeyFile)
+			return
+		}
+		opts = append(opts, opt)
+	}
+
+	if cfg.User != "" {
+		opts = append(opts, nats.UserInfo(cfg.User, string(cfg.Pass)))
+	}
+
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(string(cfg.Token)))
+	}
+
+	if cfg.CaFile != "" {
+		opts = append(opts, nats.RootCAs(cfg.CaFile))
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		err = errors.Errorf("both or neither of cert and key files are required")
 		return
 	}
+	if cfg.CertFile != "" {
+		opts = append(opts, nats.ClientCert(cfg.CertFile, cfg.KeyFile))
+	}
+
+	return
+}
+
+func keyValue(nc *nats.Conn, bucket string) (kv KeyValue, err error) {
 
 	js, err := nc.JetStream()
 	if err != nil {
@@ -89,18 +221,43 @@ func updateChannel(url, bucket, key string) (updates <-chan nats.KeyValueEntry,
 		return
 	}
 
-	kv, err := js.KeyValue(bucket)
+	kv, err = js.KeyValue(bucket)
+	err = errors.Wrap(err, "failed to get kv store")
+	return
+}
+
+func (nt *Nats) watch() (err error) {
+
+	kv, err := nt.dial()
 	if err != nil {
-		err = errors.Wrap(err, "failed to get kv store")
 		return
 	}
 
-	watcher, err := kv.Watch(key)
+	nt.watcher, err = kv.Watch(nt.Key)
 	if err != nil {
 		err = errors.Wrap(err, "failed to get kv watcher")
 		return
 	}
 
-	updates = watcher.Updates()
+	nt.updates = nt.watcher.Updates()
 	return
 }
+
+func (nt *Nats) markSynced() {
+
+	select {
+	case <-nt.synced:
+	default:
+		close(nt.synced)
+	}
+}
+
+func (nt *Nats) stop() {
+
+	if nt.watcher != nil {
+		_ = nt.watcher.Stop()
+	}
+
+	nt.watcher = nil
+	nt.updates = nil
+}
@@ -0,0 +1,343 @@
+package nats_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+
+	. "configstate/nats"
+)
+
+func TestNats(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Nats Suite")
+}
+
+var _ = Describe("Nats", func() {
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		nt     *Nats
+		data   []byte
+		err    error
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("polling with an injected kv store", func() {
+		var (
+			kv *fakeKv
+		)
+
+		BeforeEach(func() {
+			kv = &fakeKv{}
+			kv.push(entry("one"), nil)
+
+			nt, err = NewWithKv(kv, "TestoKey")
+			Expect(err).ToNot(HaveOccurred())
+			nt.Limiter = rate.NewLimiter(rate.Inf, 0)
+		})
+
+		JustBeforeEach(func() {
+			data, err = nt.Poll(ctx)
+		})
+
+		When("all is well", func() {
+			It("responds with the value and watches the key", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal("one"))
+				Expect(kv.keys).To(Equal([]string{"TestoKey"}))
+			})
+
+			It("skips the initial values marker and signals synced", func() {
+				Expect(nt.Synced()).ToNot(BeClosed())
+
+				kv.push(entry("two"))
+				data, err = nt.Poll(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal("two"))
+				Expect(nt.Synced()).To(BeClosed())
+			})
+		})
+
+		When("the watcher channel closes", func() {
+			BeforeEach(func() {
+				kv.close()
+			})
+
+			It("returns an error and re-watches on the next poll", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("closed"))
+
+				kv.push(entry("two"))
+				data, err = nt.Poll(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal("two"))
+				Expect(kv.keys).To(HaveLen(2))
+			})
+		})
+
+		When("ctx is cancelled", func() {
+			BeforeEach(func() {
+				kv.drain()
+				cancel()
+			})
+
+			It("returns canceled", func() {
+				Expect(err).To(MatchError(context.Canceled))
+			})
+		})
+	})
+
+	Describe("polling a nats server", func() {
+		var (
+			srv *server.Server
+			nc  *nats.Conn
+			kv  nats.KeyValue
+			cfg *Config
+		)
+
+		BeforeEach(func() {
+			srv, err = server.NewServer(&server.Options{
+				Host:      "127.0.0.1",
+				Port:      -1,
+				JetStream: true,
+				StoreDir:  GinkgoT().TempDir(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			go srv.Start()
+			Expect(srv.ReadyForConnections(5 * time.Second)).To(BeTrue())
+
+			nc, err = nats.Connect(srv.ClientURL())
+			Expect(err).ToNot(HaveOccurred())
+
+			js, err := nc.JetStream()
+			Expect(err).ToNot(HaveOccurred())
+
+			kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "dynafig"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = kv.Put("TestoKey", []byte(`{"thing":"one"}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = &Config{
+				Url:    srv.ClientURL(),
+				Bucket: "dynafig",
+				Key:    "TestoKey",
+			}
+		})
+
+		AfterEach(func() {
+			nc.Close()
+			srv.Shutdown()
+		})
+
+		When("dialing its own connection", func() {
+			BeforeEach(func() {
+				nt, err = cfg.New()
+				Expect(err).ToNot(HaveOccurred())
+				nt.Limiter = rate.NewLimiter(rate.Inf, 0)
+			})
+
+			It("responds with the current value and then updates", func() {
+				data, err = nt.Poll(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(`{"thing":"one"}`))
+
+				_, err = kv.Put("TestoKey", []byte(`{"thing":"two"}`))
+				Expect(err).ToNot(HaveOccurred())
+
+				data, err = nt.Poll(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(`{"thing":"two"}`))
+				Expect(nt.Synced()).To(BeClosed())
+			})
+		})
+
+		When("using an existing connection", func() {
+			BeforeEach(func() {
+				nt, err = cfg.NewWithConn(nc)
+				Expect(err).ToNot(HaveOccurred())
+				nt.Limiter = rate.NewLimiter(rate.Inf, 0)
+			})
+
+			It("responds with the current value", func() {
+				data, err = nt.Poll(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(`{"thing":"one"}`))
+			})
+
+			It("does not re-connect once the connection is closed", func() {
+				nc.Close()
+
+				Eventually(func() error {
+					_, err := nt.Poll(ctx)
+					return err
+				}).Should(MatchError(ContainSubstring("connection is closed")))
+			})
+		})
+
+		When("the bucket does not exist", func() {
+			BeforeEach(func() {
+				cfg.Bucket = "bargle"
+			})
+
+			It("fails to create", func() {
+				_, err = cfg.New()
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		When("only one of cert and key is configured", func() {
+			BeforeEach(func() {
+				cfg.CertFile = "client.pem"
+			})
+
+			It("fails to create", func() {
+				_, err = cfg.New()
+				Expect(err).To(MatchError(ContainSubstring("cert and key")))
+			})
+		})
+	})
+
+	Describe("polling a nats server requiring auth", func() {
+		var (
+			srv *server.Server
+			cfg *Config
+		)
+
+		BeforeEach(func() {
+			srv, err = server.NewServer(&server.Options{
+				Host:      "127.0.0.1",
+				Port:      -1,
+				JetStream: true,
+				StoreDir:  GinkgoT().TempDir(),
+				Username:  "dsc",
+				Password:  "s3cret",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			go srv.Start()
+			Expect(srv.ReadyForConnections(5 * time.Second)).To(BeTrue())
+
+			nc, err := nats.Connect(srv.ClientURL(), nats.UserInfo("dsc", "s3cret"))
+			Expect(err).ToNot(HaveOccurred())
+			defer nc.Close()
+
+			js, err := nc.JetStream()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "dynafig"})
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg = &Config{
+				Url:    srv.ClientURL(),
+				Bucket: "dynafig",
+				Key:    "TestoKey",
+			}
+		})
+
+		AfterEach(func() {
+			srv.Shutdown()
+		})
+
+		When("user and pass are configured", func() {
+			BeforeEach(func() {
+				cfg.User = "dsc"
+				cfg.Pass = "s3cret"
+			})
+
+			It("connects and watches", func() {
+				_, err = cfg.New()
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("user and pass are not configured", func() {
+			It("fails to connect", func() {
+				_, err = cfg.New()
+				Expect(err).To(MatchError(ContainSubstring("failed to connect")))
+			})
+		})
+	})
+})
+
+// fakeKv is an injectable kv store, handing out watchers on a shared updates channel.
+type fakeKv struct {
+	keys    []string
+	updates chan nats.KeyValueEntry
+}
+
+func (kv *fakeKv) Watch(key string, opts ...nats.WatchOpt) (nats.KeyWatcher, error) {
+
+	kv.keys = append(kv.keys, key)
+	if kv.updates == nil {
+		kv.updates = make(chan nats.KeyValueEntry, 9)
+	}
+
+	return &fakeWatcher{updates: kv.updates}, nil
+}
+
+func (kv *fakeKv) push(entries ...nats.KeyValueEntry) {
+
+	if kv.updates == nil {
+		kv.updates = make(chan nats.KeyValueEntry, 9)
+	}
+	for _, kve := range entries {
+		kv.updates <- kve
+	}
+}
+
+func (kv *fakeKv) drain() {
+
+	for len(kv.updates) > 0 {
+		<-kv.updates
+	}
+}
+
+func (kv *fakeKv) close() {
+
+	kv.drain()
+	close(kv.updates)
+	kv.updates = nil
+}
+
+type fakeWatcher struct {
+	nats.KeyWatcher
+	updates chan nats.KeyValueEntry
+}
+
+func (fw *fakeWatcher) Updates() <-chan nats.KeyValueEntry {
+	return fw.updates
+}
+
+func (fw *fakeWatcher) Stop() error {
+	return nil
+}
+
+type fakeEntry struct {
+	nats.KeyValueEntry
+	value []byte
+}
+
+func (fe *fakeEntry) Value() []byte {
+	return fe.value
+}
+
+func entry(value string) nats.KeyValueEntry {
+	return &fakeEntry{value: []byte(value)}
+}